@@ -4,8 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"github.com/randall77/hprof/read"
+	"math"
+	"os"
 )
 
+var maxDepth = flag.Int("depth", 0, "maximum number of edges to follow from a root when finding reachable objects (0 for no limit)")
+var retained = flag.Bool("retained", false, "size and color objects by the number of bytes they retain")
+
 func main() {
 	flag.Parse()
 	args := flag.Args()
@@ -16,58 +21,18 @@ func main() {
 		d = read.Read(args[0], "")
 	}
 
-	// eliminate unreachable objects
-	// TODO: have reader do this?
-	reachable := make([]bool, d.NumObjects())
-	var q []read.ObjId
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
-	}
-	for _, x := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range x.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
-	}
-	for _, r := range d.Otherroots {
-		for _, e := range r.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
-		}
+	// find unreachable objects
+	reachable, truncated := d.ReachableDepth(*maxDepth)
+	if truncated {
+		fmt.Fprintf(os.Stderr, "reachability truncated at depth %d, deeper objects are shown as unreachable\n", *maxDepth)
 	}
-	for _, f := range d.QFinal {
-		for _, e := range f.Edges {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
-			}
 
-		}
-	}
-	for _, g := range d.Goroutines {
-		if g.Ctxt != read.ObjNil {
-			if !reachable[g.Ctxt] {
-				reachable[g.Ctxt] = true
-				q = append(q, g.Ctxt)
-			}
-		}
-	}
-	for len(q) > 0 {
-		x := q[0]
-		q = q[1:]
-		for _, e := range d.Edges(x) {
-			if !reachable[e.To] {
-				reachable[e.To] = true
-				q = append(q, e.To)
+	// biggest retained size, for scaling the others
+	var maxRetained uint64
+	if *retained {
+		for i := 0; i < d.NumObjects(); i++ {
+			if r := d.RetainedSize(read.ObjId(i)); r > maxRetained {
+				maxRetained = r
 			}
 		}
 	}
@@ -77,10 +42,23 @@ func main() {
 	// print object graph
 	for i := 0; i < d.NumObjects(); i++ {
 		x := read.ObjId(i)
-		if !reachable[x] {
+		if !reachable.Has(x) {
 			fmt.Printf("  v%d [style=filled fillcolor=gray];\n", x)
 		}
-		fmt.Printf("  v%d [label=\"%s\\n%d\"];\n", x, d.Ft(x).Name, d.Size(x))
+		if *retained {
+			// node area and color saturation grow with retained size
+			r := d.RetainedSize(x)
+			f := 0.0
+			if maxRetained > 0 {
+				f = float64(r) / float64(maxRetained)
+			}
+			fmt.Printf("  v%d [label=\"%s\\n%d\\nretains %d\" width=%.2f];\n", x, d.Ft(x).Name, d.Size(x), r, 0.75+3*math.Sqrt(f))
+			if reachable.Has(x) {
+				fmt.Printf("  v%d [style=filled fillcolor=\"0.000 %.3f 1.000\"];\n", x, f)
+			}
+		} else {
+			fmt.Printf("  v%d [label=\"%s\\n%d\"];\n", x, d.Ft(x).Name, d.Size(x))
+		}
 		for _, e := range d.Edges(x) {
 			var taillabel, headlabel string
 			if e.FieldName != "" {
@@ -99,6 +77,13 @@ func main() {
 	for _, t := range d.Goroutines {
 		fmt.Printf("  \"goroutines\" [shape=diamond];\n")
 		fmt.Printf("  \"goroutines\" -> f%x_0;\n", t.Bos.Addr)
+		for _, e := range t.Edges {
+			var headlabel string
+			if e.ToOffset != 0 {
+				headlabel = fmt.Sprintf(" [headlabel=\"%d\"]", e.ToOffset)
+			}
+			fmt.Printf("  f%x_0 -> v%d [taillabel=\"%s\"]%s;\n", t.Bos.Addr, e.To, e.FieldName, headlabel)
+		}
 	}
 
 	// stack frames
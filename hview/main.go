@@ -1,6 +1,7 @@
 package main
 
 import (
+	"debug/elf"
 	"flag"
 	"fmt"
 	"github.com/randall77/hprof/read"
@@ -12,7 +13,9 @@ import (
 	"runtime/debug"
 	"sort"
 	"strconv"
+	"strings"
 	"text/template"
+	"time"
 )
 
 const (
@@ -21,7 +24,9 @@ const (
 )
 
 var (
-	httpAddr = flag.String("http", defaultAddr, "HTTP service address")
+	httpAddr     = flag.String("http", defaultAddr, "HTTP service address")
+	coreFile     = flag.String("core", "", "core file of the dumped process, whose registers are used as extra roots")
+	excludeTypes = flag.String("exclude-types", "", "comma-separated full type names whose objects are treated as retaining nothing")
 )
 
 // d is the loaded heap dump.
@@ -33,7 +38,7 @@ func typeLink(ft *read.FullType) string {
 }
 
 func objLink(x read.ObjId) string {
-	return fmt.Sprintf("<a href=obj?id=%d>object %x</a>", x, d.Addr(x))
+	return fmt.Sprintf("<a href=obj?id=%d>object %s</a>", x, d.FormatAddr(d.Addr(x)))
 }
 
 // returns an html string representing the target of an Edge
@@ -65,7 +70,7 @@ func nonheapPtr(b []byte) string {
 		return "nil"
 	} else {
 		// TODO: look up symbol in executable
-		return fmt.Sprintf("outsideheap_%x", p)
+		return "outsideheap_" + d.FormatAddr(p)
 	}
 }
 
@@ -222,7 +227,7 @@ func getFields(b []byte, fields []read.Field, edges []read.Edge) []Field {
 }
 
 type objInfo struct {
-	Addr      uint64
+	Addr      string
 	Typ       string
 	Size      uint64
 	Fields    []Field
@@ -243,11 +248,11 @@ table, td, th
 border:1px solid grey;
 }
 </style>
-<title>Object {{printf "%x" .Addr}}</title>
+<title>Object {{.Addr}}</title>
 </head>
 <body>
 <tt>
-<h2>Object {{printf "%x" .Addr}} : {{.Typ}}</h2>
+<h2>Object {{.Addr}} : {{.Typ}}</h2>
 <h3>{{.Size}} bytes</h3>
 <table>
 <tr>
@@ -309,12 +314,12 @@ func objHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	info := objInfo{
-		d.Addr(x),
+		d.FormatAddr(d.Addr(x)),
 		typeLink(d.Ft(x)),
 		d.Size(x),
 		fld,
 		ref,
-		domsize[x],
+		d.RetainedSize(x),
 	}
 	if err := objTemplate.Execute(w, info); err != nil {
 		log.Print(err)
@@ -450,8 +455,17 @@ type mainInfo struct {
 	HeapSize   uint64
 	HeapUsed   uint64
 	NumObjects int
+	ObjBytes   uint64
+	Version    string
+	Arch       string
+	NumCPU     uint64
+	States     []read.Tally
+	Waits      []read.Tally
 }
 
+// number of wait reasons shown on the main page
+const maxWaitReasons = 5
+
 var mainTemplate = template.Must(template.New("histo").Parse(`
 <html>
 <head>
@@ -466,7 +480,15 @@ Heap size: {{.HeapSize}} bytes
 <br>
 Heap live: {{.HeapUsed}} bytes
 <br>
-Heap objects: {{.NumObjects}}
+Heap objects: {{.NumObjects}}, {{.ObjBytes}} bytes
+<br>
+Dump format: {{.Version}}
+<br>
+Architecture: {{.Arch}}, {{.NumCPU}} cpus
+<br>
+Goroutines:{{range .States}} {{.Count}} {{.Name}}{{end}}
+<br>
+Top wait reasons:{{range .Waits}} {{.Count}} {{.Name}}{{end}}
 <br>
 <a href="histo">Type Histogram</a>
 <a href="globals">Globals</a>
@@ -478,7 +500,11 @@ Heap objects: {{.NumObjects}}
 `))
 
 func mainHandler(w http.ResponseWriter, r *http.Request) {
-	i := mainInfo{d.HeapEnd - d.HeapStart, d.Memstats.Alloc, d.NumObjects()}
+	waits := d.WaitReasons()
+	if len(waits) > maxWaitReasons {
+		waits = waits[:maxWaitReasons]
+	}
+	i := mainInfo{d.HeapEnd - d.HeapStart, d.Memstats.Alloc, d.NumObjects(), d.TotalShallowSize(), d.FormatVersion(), d.Arch(), d.NumCPU(), d.GoroutineStates(), waits}
 	if err := mainTemplate.Execute(w, i); err != nil {
 		log.Print(err)
 	}
@@ -552,7 +578,7 @@ border:1px solid grey;
 <table>
 <tr>
 <td>Name</td>
-<td>Type</td>
+<td>Kind</td>
 <td>Value</td>
 </tr>
 {{range .}}
@@ -572,7 +598,7 @@ func othersHandler(w http.ResponseWriter, r *http.Request) {
 	var f []Field
 	for _, x := range d.Otherroots {
 		for _, e := range x.Edges {
-			f = append(f, Field{x.Description, "unknown", edgeLink(e)})
+			f = append(f, Field{x.Description, x.Kind().String(), edgeLink(e)})
 		}
 	}
 	if err := othersTemplate.Execute(w, f); err != nil {
@@ -583,6 +609,7 @@ func othersHandler(w http.ResponseWriter, r *http.Request) {
 type goListInfo struct {
 	Name  string
 	State string
+	Wait  time.Duration // lower bound on time spent blocked
 }
 
 var goListTemplate = template.Must(template.New("golist").Parse(`
@@ -607,11 +634,13 @@ border:1px solid grey;
 <tr>
 <td>Name</td>
 <td>State</td>
+<td>Blocked for</td>
 </tr>
 {{range .}}
 <tr>
 <td>{{.Name}}</td>
 <td>{{.State}}</td>
+<td>{{if .Wait}}&ge;{{.Wait}}{{end}}</td>
 </tr>
 {{end}}
 </table>
@@ -623,28 +652,11 @@ border:1px solid grey;
 func goListHandler(w http.ResponseWriter, r *http.Request) {
 	var i []goListInfo
 	for _, g := range d.Goroutines {
-		name := fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", g.Addr, g.Addr)
-		var state string
-		switch g.Status {
-		case 0:
-			state = "idle"
-		case 1:
-			state = "runnable"
-		case 2:
-			// running - shouldn't happen
-			log.Fatal("found running goroutine in heap dump")
-		case 3:
-			state = "syscall"
-		case 4:
-			state = g.WaitReason
-		case 5:
-			state = "dead"
-		default:
-			log.Fatal("unknown goroutine status")
-		}
-		i = append(i, goListInfo{name, state})
+		name := fmt.Sprintf("<a href=go?id=%x>goroutine %s</a>", g.Addr, d.FormatAddr(g.Addr))
+		wait, _ := d.WaitDuration(g)
+		i = append(i, goListInfo{name, g.StatusString(), wait})
 	}
-	// sort by state
+	// sort by state, longest blocked first
 	sort.Sort(ByState(i))
 	if err := goListTemplate.Execute(w, i); err != nil {
 		log.Print(err)
@@ -653,14 +665,20 @@ func goListHandler(w http.ResponseWriter, r *http.Request) {
 
 type ByState []goListInfo
 
-func (a ByState) Len() int           { return len(a) }
-func (a ByState) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByState) Less(i, j int) bool { return a[i].State < a[j].State }
+func (a ByState) Len() int      { return len(a) }
+func (a ByState) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByState) Less(i, j int) bool {
+	if a[i].State != a[j].State {
+		return a[i].State < a[j].State
+	}
+	return a[i].Wait > a[j].Wait
+}
 
 type goInfo struct {
-	Addr   uint64
+	Addr   string
 	Obj    read.ObjId
 	State  string
+	Ctxt   string
 	Frames []string
 }
 
@@ -677,12 +695,13 @@ table, td, th
 border:1px solid grey;
 }
 </style>
-<title>Goroutine {{printf "%x" .Addr}}</title>
+<title>Goroutine {{.Addr}}</title>
 </head>
 <body>
 <tt>
-<h2>Goroutine <a href=obj?id={{.Obj}}>{{printf "%x" .Addr}}</a></h2>
+<h2>Goroutine <a href=obj?id={{.Obj}}>{{.Addr}}</a></h2>
 <h3>{{.State}}</h3>
+{{if .Ctxt}}<h3>Context {{.Ctxt}}</h3>{{end}}
 <h3>Stack</h3>
 {{range .Frames}}
 {{.}}
@@ -718,24 +737,11 @@ func goHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var i goInfo
-	i.Addr = g.Addr
+	i.Addr = d.FormatAddr(g.Addr)
 	i.Obj = d.FindObj(g.Addr)
-	switch g.Status {
-	case 0:
-		i.State = "idle"
-	case 1:
-		i.State = "runnable"
-	case 2:
-		// running - shouldn't happen
-		log.Fatal("found running goroutine in heap dump")
-	case 3:
-		i.State = "syscall"
-	case 4:
-		i.State = g.WaitReason
-	case 5:
-		i.State = "dead"
-	default:
-		log.Fatal("unknown goroutine status")
+	i.State = g.StatusString()
+	if g.Ctxt != read.ObjNil {
+		i.Ctxt = fmt.Sprintf("%s : %s", objLink(g.Ctxt), typeLink(d.Ft(g.Ctxt)))
 	}
 
 	for f := g.Bos; f != nil; f = f.Parent {
@@ -833,7 +839,7 @@ func frameHandler(w http.ResponseWriter, r *http.Request) {
 	i.Addr = f.Addr
 	i.Name = f.Name
 	i.Depth = f.Depth
-	i.Goroutine = fmt.Sprintf("<a href=go?id=%x>goroutine %x</a>", f.Goroutine.Addr, f.Goroutine.Addr)
+	i.Goroutine = fmt.Sprintf("<a href=go?id=%x>goroutine %s</a>", f.Goroutine.Addr, d.FormatAddr(f.Goroutine.Addr))
 
 	// variables
 	i.Vars = getFields(f.Data, f.Fields, f.Edges)
@@ -882,6 +888,22 @@ func main() {
 
 	fmt.Println("Loading...")
 	d = read.Read(dump, exec)
+	for _, p := range d.Validate() {
+		log.Print(p)
+	}
+	if *coreFile != "" {
+		f, err := elf.Open(*coreFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := d.AddCoreRoots(f); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+	if *excludeTypes != "" {
+		d.SetNonRetainingTypes(strings.Split(*excludeTypes, ","))
+	}
 
 	fmt.Println("Analyzing...")
 	prepare()
@@ -941,6 +963,13 @@ func getReferrers(x read.ObjId) []string {
 			}
 		}
 	}
+	for _, g := range d.Goroutines {
+		for _, e := range g.Edges {
+			if e.To == x {
+				r = append(r, fmt.Sprintf("<a href=go?id=%x>goroutine %s</a>.%s", g.Addr, d.FormatAddr(g.Addr), e.FieldName))
+			}
+		}
+	}
 	for _, s := range d.Otherroots {
 		for _, e := range s.Edges {
 			if e.To == x {
@@ -992,134 +1021,8 @@ func prepare() {
 		}
 	}
 
-	dom()
-}
-
-// map from object ID to the size of the heap that is dominated by that object.
-var domsize []uint64
-
-func dom() {
 	fmt.Println("Computing dominators...")
-	n := d.NumObjects()
-
-	// make list of roots
-	// TODO: have loader compute this?
-	roots := map[read.ObjId]struct{}{}
-	for _, s := range []*read.Data{d.Data, d.Bss} {
-		for _, e := range s.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-	for _, f := range d.Frames {
-		for _, e := range f.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-	for _, x := range d.Otherroots {
-		for _, e := range x.Edges {
-			roots[e.To] = struct{}{}
-		}
-	}
-
-	// compute postorder traversal
-	// object states:
-	// 0 - not seen yet
-	// 1 - seen, added to queue, not yet expanded children
-	// 2 - seen, already expanded children
-	// 3 - added to postorder
-	postorder := make([]read.ObjId, 0, n)
-	postnum := make([]int, n+1)
-	state := make([]byte, n)
-	var q []read.ObjId // stack of work to do, holds state 1 and 2 objects
-	for x := range roots {
-		if state[x] != 0 {
-			if state[x] != 3 {
-				log.Fatal("bad state found")
-			}
-			continue
-		}
-		state[x] = 1
-		q = q[:0]
-		q = append(q, x)
-		for len(q) > 0 {
-			y := q[len(q)-1]
-			if state[y] == 2 {
-				state[y] = 3
-				q = q[:len(q)-1]
-				postnum[y] = len(postorder)
-				postorder = append(postorder, y)
-			} else {
-				if state[y] != 1 {
-					log.Fatal("bad state")
-				}
-				state[y] = 2
-				for _, e := range d.Edges(y) {
-					z := e.To
-					if state[z] == 0 {
-						state[z] = 1
-						q = append(q, z)
-					}
-				}
-			}
-		}
-	}
-	postnum[n] = n // virtual start node
-
-	// compute immediate dominators
-	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
-	idom := make([]read.ObjId, n+1)
-	for i := 0; i < n; i++ {
-		idom[i] = read.ObjNil
-	}
-	idom[n] = read.ObjId(n)
-	for r := range roots {
-		idom[r] = read.ObjId(n)
-	}
-	var redges []read.ObjId
-	change := true
-	for change {
-		change = false
-		for i := len(postorder) - 1; i >= 0; i-- {
-			x := postorder[i]
-			// get list of incoming edges
-			redges = redges[:0]
-			if ref1[x] != read.ObjNil {
-				redges = append(redges, ref1[x])
-				redges = append(redges, ref2[x]...)
-			}
-			a := read.ObjNil
-			for _, b := range redges {
-				if idom[b] == read.ObjNil {
-					continue
-				}
-				if a == read.ObjNil {
-					a = b
-					continue
-				}
-				for a != b {
-					if postnum[a] < postnum[b] {
-						a = idom[a]
-					} else {
-						b = idom[b]
-					}
-				}
-			}
-			if _, ok := roots[x]; ok {
-				a = read.ObjId(n)
-			}
-			if a != idom[x] {
-				idom[x] = a
-				change = true
-			}
-		}
-	}
-
-	domsize = make([]uint64, n+1)
-	for _, x := range postorder {
-		domsize[x] += d.Size(x)
-		domsize[idom[x]] += domsize[x]
-	}
-	// Note: unreachable objects will have domsize of 0.
+	d.ComputeDominators()
 }
 
 func readPtr(b []byte) uint64 {
@@ -1129,7 +1032,7 @@ func readPtr(b []byte) uint64 {
 	case 8:
 		return d.Order.Uint64(b)
 	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
+		log.Fatalf("unsupported PtrSize=%d", d.PtrSize)
 		return 0
 	}
 }
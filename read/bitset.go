@@ -0,0 +1,45 @@
+package read
+
+import "math/bits"
+
+// A Bitset is a set of objects, stored as one bit per ObjId.  For
+// heaps with tens of millions of objects this is much smaller than a
+// map or a []bool.
+type Bitset []uint64
+
+// NewBitset returns an empty set which can hold ObjIds 0 to n-1.
+func NewBitset(n int) Bitset {
+	return make(Bitset, (n+63)/64)
+}
+
+// Has reports whether x is in the set.
+func (b Bitset) Has(x ObjId) bool {
+	return b[x/64]&(1<<(uint(x)%64)) != 0
+}
+
+// Add adds x to the set.
+func (b Bitset) Add(x ObjId) {
+	b[x/64] |= 1 << (uint(x) % 64)
+}
+
+// Count returns the number of objects in the set.
+func (b Bitset) Count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Objects returns the objects in the set, in increasing order.
+func (b Bitset) Objects() []ObjId {
+	var r []ObjId
+	for i, w := range b {
+		for w != 0 {
+			j := bits.TrailingZeros64(w)
+			r = append(r, ObjId(i*64+j))
+			w &= w - 1
+		}
+	}
+	return r
+}
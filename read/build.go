@@ -0,0 +1,106 @@
+package read
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+)
+
+// NewDump returns an empty Dump for building a heap by hand, e.g. to
+// test an analysis on a small graph, or to analyze heap-like data
+// from somewhere other than a heap dump.  Add types, objects, and
+// roots with AddType, AddObject, and AddRoot, then call Link once.
+// The Dump can't be used for anything else before Link.
+func NewDump(order binary.ByteOrder, ptrSize uint64) *Dump {
+	if ptrSize != 4 && ptrSize != 8 {
+		Logger.Fatalf("NewDump: unsupported pointer size %d", ptrSize)
+	}
+	return &Dump{
+		Order:     order,
+		PtrSize:   ptrSize,
+		HChanSize: minHChanSize(ptrSize),
+		Ncpu:      1,
+		Memstats:  &runtime.MemStats{},
+		Data:      &Data{},
+		Bss:       &Data{},
+		TypeMap:   map[uint64]*Type{},
+		ItabMap:   map[uint64]bool{},
+		ftmap:     map[tkey]*FullType{},
+		version:   dumpVersion,
+	}
+}
+
+// AddType adds a type with the given name, size, and fields, which
+// must be in increasing offset order.  Only the pointer-bearing fields
+// (pointers, strings, slices, and interfaces) are needed to find edges.
+func (d *Dump) AddType(name string, size uint64, fields []Field) *Type {
+	t := &Type{
+		Name:       name,
+		Size:       size,
+		Fields:     fields,
+		Addr:       uint64(len(d.Types) + 1), // any unique nonzero value
+		dumpFields: append([]Field(nil), fields...),
+	}
+	d.Types = append(d.Types, t)
+	d.TypeMap[t.Addr] = t
+	return t
+}
+
+// AddObject adds an object at address addr holding contents.  t is
+// the type of the object (or of its elements, for arrays and
+// channels), or nil if it has no pointers.  The object's ObjId is
+// only known after Link; use FindObj(addr) to get it.
+func (d *Dump) AddObject(addr uint64, t *Type, kind TypeKind, contents []byte) {
+	var typaddr uint64
+	if t != nil {
+		if d.TypeMap[t.Addr] != t {
+			Logger.Fatalf("AddObject: type %s was not added to this Dump", t.Name)
+		}
+		typaddr = t.Addr
+	} else if kind != TypeKindObject && kind != TypeKindConservative {
+		Logger.Fatalf("AddObject: kind %d needs a type", kind)
+	}
+	size := uint64(len(contents))
+	k := tkey{typaddr, kind, size}
+	ft := d.ftmap[k]
+	if ft == nil {
+		ft = d.makeFullType(typaddr, kind, size)
+		d.ftmap[k] = ft
+	}
+	d.objects = append(d.objects, object{ft, int64(len(d.mem)), addr})
+	d.mem = append(d.mem, contents...)
+}
+
+// AddRoot adds an OtherRoot with the description desc pointing to
+// address addr.
+func (d *Dump) AddRoot(desc string, addr uint64) {
+	d.Otherroots = append(d.Otherroots, &OtherRoot{Description: desc, toaddr: addr})
+}
+
+// Link finishes building d: it finds the edges between the objects
+// and from the roots, as the reader does for a dump file.  If
+// HeapStart and HeapEnd haven't been set, the heap is taken to be the
+// range spanned by the objects.
+func (d *Dump) Link() {
+	if d.HeapStart == 0 && d.HeapEnd == 0 && len(d.objects) > 0 {
+		d.HeapStart = d.objects[0].Addr
+		for _, x := range d.objects {
+			if x.Addr < d.HeapStart {
+				d.HeapStart = x.Addr
+			}
+			if e := x.Addr + x.Ft.Size; e > d.HeapEnd {
+				d.HeapEnd = e
+			}
+		}
+	}
+	for _, x := range d.objects {
+		if x.Addr < d.HeapStart || x.Addr+x.Ft.Size > d.HeapEnd {
+			Logger.Fatalf("Link: object at %s is outside the heap", d.FormatAddr(x.Addr))
+		}
+	}
+	d.r = bytes.NewReader(d.mem)
+	d.ftmap = nil
+	d.mem = nil
+	nameFullTypes(d)
+	link(d)
+}
@@ -0,0 +1,297 @@
+package read
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// A Mismatch describes a place where the dump disagrees with
+// the Dwarf info of the executable.
+type Mismatch struct {
+	Type   *Type
+	Offset uint64 // offset of the mismatched field (0 for size mismatches)
+	Msg    string
+}
+
+// dwarfSizeMismatch returns a Mismatch if the dump type t and the
+// Dwarf type dt have different sizes.
+func dwarfSizeMismatch(t *Type, dt dwarfType) (Mismatch, bool) {
+	if dt.Size() == t.Size {
+		return Mismatch{}, false
+	}
+	return Mismatch{t, 0, fmt.Sprintf("dwarf size doesn't match dump size %s dwarf=%d dump=%d", t.Name, dt.Size(), t.Size)}, true
+}
+
+// dwarfMismatches returns the ways in which the pointer layout of the
+// dump type t is inconsistent with the Dwarf type dt.  A field in the
+// heap dump must match the corresponding Dwarf field in both kind and
+// offset, and the Dwarf type must not have any pointer fields the dump
+// doesn't know about.
+func dwarfMismatches(t *Type, dt dwarfType) []Mismatch {
+	var r []Mismatch
+
+	// load Dwarf fields into layout
+	layout := make(map[uint64]Field)
+	for _, f := range dt.Fields() {
+		layout[f.Offset] = f
+	}
+	for _, f := range t.dumpFields {
+		if layout[f.Offset].Kind != f.Kind {
+			r = append(r, Mismatch{t, f.Offset, fmt.Sprintf("dwarf field kind doesn't match dump kind %s.%d dwarf=%d dump=%d", t.Name, f.Offset, layout[f.Offset].Kind, f.Kind)})
+		}
+		delete(layout, f.Offset)
+	}
+	// all remaining fields must not be pointer-containing
+	for _, f := range layout {
+		switch f.Kind {
+		case FieldKindPtr, FieldKindString, FieldKindSlice, FieldKindIface, FieldKindEface:
+			r = append(r, Mismatch{t, f.Offset, fmt.Sprintf("dwarf type has additional ptr field %s %d %d", f.Name, f.Offset, f.Kind)})
+		}
+	}
+	return r
+}
+
+// CrossCheckDWARF compares the size and pointer layout of every type
+// in the dump with the type of the same name in the Dwarf info w and
+// returns all the discrepancies found.  Lots of mismatches usually
+// mean w is not from the executable that wrote the dump.
+func (d *Dump) CrossCheckDWARF(w *dwarf.Data) []Mismatch {
+	m := make(map[string]dwarfType)
+	for _, x := range typeMap(d, w) {
+		m[x.Name()] = x
+	}
+	var r []Mismatch
+	for _, t := range d.Types {
+		dt := m[t.Name]
+		if dt == nil {
+			continue
+		}
+		if x, ok := dwarfSizeMismatch(t, dt); ok {
+			r = append(r, x)
+		}
+		r = append(r, dwarfMismatches(t, dt)...)
+	}
+	return r
+}
+
+// OverlappingObjects returns pairs of objects whose address ranges
+// overlap.  Overlaps mean the dump is corrupt (or misparsed): FindObj
+// can only return one of the objects at an address, so the object
+// graph can't be trusted.
+func (d *Dump) OverlappingObjects() [][2]ObjId {
+	var r [][2]ObjId
+	// Objects are sorted by address, so an object overlaps an
+	// earlier one iff it starts before the furthest end seen so far.
+	last := ObjNil
+	var end uint64
+	for i := range d.objects {
+		x := &d.objects[i]
+		if last != ObjNil && x.Addr < end {
+			r = append(r, [2]ObjId{last, ObjId(i)})
+		}
+		if e := x.Addr + x.Ft.Size; last == ObjNil || e > end {
+			last = ObjId(i)
+			end = e
+		}
+	}
+	return r
+}
+
+// Validate checks the dump for signs that it was misparsed and
+// returns a description of each problem found.  It is cheap, so it is
+// worth calling before starting any analysis.
+func (d *Dump) Validate() []string {
+	var r []string
+	if d.Ncpu == 0 {
+		r = append(r, "params record has ncpu=0")
+	}
+	if d.PtrSize != 4 && d.PtrSize != 8 {
+		r = append(r, fmt.Sprintf("params record has bad pointer size %d", d.PtrSize))
+	}
+	if a, ok := archs[d.TheChar]; ok && a.ptrSize != d.PtrSize {
+		r = append(r, fmt.Sprintf("pointer size %d doesn't match arch %s", d.PtrSize, a.name))
+	}
+	if a, ok := archs[d.TheChar]; ok && a.order != nil && a.order != d.Order {
+		r = append(r, fmt.Sprintf("byte order %s doesn't match arch %s", d.Order, a.name))
+	}
+	if min := minHChanSize(d.PtrSize); d.HChanSize < min {
+		r = append(r, fmt.Sprintf("channel header size %d is smaller than the minimum %d", d.HChanSize, min))
+	}
+	lost := 0
+	index := make(map[*StackFrame]int, len(d.Frames))
+	for i, f := range d.Frames {
+		if f.Goroutine == nil {
+			lost++
+		}
+		index[f] = i
+	}
+	if lost > 0 {
+		r = append(r, fmt.Sprintf("%d stack frames are not on any goroutine's stack", lost))
+	}
+	for _, g := range d.Goroutines {
+		var last *StackFrame
+		depth := uint64(0)
+		for f := g.Bos; f != nil; f = f.Parent {
+			if f.Depth != depth {
+				r = append(r, fmt.Sprintf("goroutine %s has frame %s at depth %d, want depth %d", d.FormatAddr(g.Addr), f.Name, f.Depth, depth))
+				break
+			}
+			last = f
+			depth++
+		}
+		if last == nil {
+			continue
+		}
+		// The frames of a stack are dumped in order, so a caller of
+		// the last frame which didn't get linked is the next record.
+		if i := index[last] + 1; i < len(d.Frames) && d.Frames[i].Depth == last.Depth+1 && d.Frames[i].Goroutine == nil {
+			r = append(r, fmt.Sprintf("stack of goroutine %s stops at frame %s at depth %d", d.FormatAddr(g.Addr), last.Name, last.Depth))
+		}
+	}
+	if d.HeapEnd < d.HeapStart {
+		r = append(r, fmt.Sprintf("heap end %s is before heap start %s", d.FormatAddr(d.HeapEnd), d.FormatAddr(d.HeapStart)))
+	}
+	// HeapObjects counts tiny allocations one by one, while the dump
+	// has one object for each block of them, so the dump may have
+	// somewhat fewer objects.  Many fewer, or more, means records
+	// are missing or extra.  Subgraphs only have some of the objects.
+	if m := d.Memstats; m != nil && m.HeapObjects != 0 && !d.extracted {
+		n := uint64(d.NumObjects())
+		if n*2 < m.HeapObjects || n*10 > m.HeapObjects*11 {
+			r = append(r, fmt.Sprintf("dump has %d objects but memstats has HeapObjects=%d", n, m.HeapObjects))
+		}
+	}
+	return r
+}
+
+// An Anomaly describes an object which doesn't look like anything the
+// runtime could have allocated.
+type Anomaly struct {
+	Obj ObjId
+	Msg string
+}
+
+const (
+	maxSmallSize = 32768
+	pageSize     = 4096
+)
+
+// sizeClasses are the sizes the go1.3 runtime's allocator rounds
+// objects of at most maxSmallSize bytes up to.  Larger objects are a
+// whole number of pages.
+var sizeClasses = makeSizeClasses()
+
+// makeSizeClasses computes the size classes the way the go1.3
+// runtime does (see InitSizes in runtime/msize.c).
+func makeSizeClasses() []uint64 {
+	var sizes, npages []uint64
+	align := uint64(8)
+	for size := align; size <= maxSmallSize; size += align {
+		if size&(size-1) == 0 { // bump alignment once in a while
+			switch {
+			case size >= 2048:
+				align = 256
+			case size >= 128:
+				align = size / 8
+			case size >= 16:
+				align = 16
+			}
+		}
+		// Use enough pages that at most 1/8 of them is wasted.
+		allocsize := uint64(pageSize)
+		for allocsize%size > allocsize/8 {
+			allocsize += pageSize
+		}
+		n := allocsize / pageSize
+		// If the previous class uses as many pages for as many
+		// objects, this size replaces it.
+		if i := len(sizes) - 1; i >= 0 && npages[i] == n && allocsize/size == allocsize/sizes[i] {
+			sizes[i] = size
+			continue
+		}
+		sizes = append(sizes, size)
+		npages = append(npages, n)
+	}
+	return sizes
+}
+
+// SizeAnomalies returns the objects whose size can't be explained by
+// their type: the object is smaller than its type, or bigger than the
+// runtime would have rounded the type's size up to.  These point to a
+// corrupt dump, or to a misunderstanding of the object's layout.
+// Objects without a known type are not checked.
+func (d *Dump) SizeAnomalies() []Anomaly {
+	var r []Anomaly
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		if ft.Typ == nil {
+			continue
+		}
+		size, need := ft.Size, ft.Typ.Size
+		if size < need {
+			r = append(r, Anomaly{ObjId(i), fmt.Sprintf("%s: object size %d is smaller than type size %d", ft.Name, size, need)})
+			continue
+		}
+		if ft.Kind != TypeKindObject {
+			// arrays and channels hold an unknown number of elements
+			continue
+		}
+		if size > maxSmallSize {
+			if size%pageSize != 0 || size-need >= pageSize {
+				r = append(r, Anomaly{ObjId(i), fmt.Sprintf("%s: large object size %d doesn't fit type size %d", ft.Name, size, need)})
+			}
+			continue
+		}
+		// Allow the smallest size class that fits, or the next one.
+		ok := false
+		for j, c := range sizeClasses {
+			if c >= need {
+				ok = size == c || j+1 < len(sizeClasses) && size == sizeClasses[j+1]
+				break
+			}
+		}
+		if !ok {
+			r = append(r, Anomaly{ObjId(i), fmt.Sprintf("%s: object size %d isn't a size class for type size %d", ft.Name, size, need)})
+		}
+	}
+	return r
+}
+
+// EdgeAnomalies returns the objects with pointers that don't land
+// strictly inside the object they point to.  FindObj guarantees that
+// edges do, so an edge landing outside its destination means the
+// object index is corrupt.  A pointer just past the end of an object,
+// which Go code can create by slicing (e.g. s[len(s):]), is not an
+// edge at all: it is attributed to no object, or to the next one.
+// When it points to no object it is reported here, so it isn't lost
+// silently.
+func (d *Dump) EdgeAnomalies() []Anomaly {
+	var r []Anomaly
+	for i := range d.objects {
+		x := ObjId(i)
+		for _, e := range d.edges(x) {
+			if e.ToOffset >= d.Size(e.To) {
+				r = append(r, Anomaly{x, fmt.Sprintf("%s: field %s points to offset %d of %d-byte object %s", d.Ft(x).Name, e.FieldName, e.ToOffset, d.Size(e.To), d.FormatAddr(d.Addr(e.To)))})
+			}
+		}
+		b := d.Contents(x)
+		if b == nil {
+			continue
+		}
+		for _, f := range d.Ft(x).Fields {
+			switch f.Kind {
+			case FieldKindPtr, FieldKindString, FieldKindSlice:
+			default:
+				continue
+			}
+			p := readPtr(d, b[f.Offset:])
+			if p <= d.HeapStart || p > d.HeapEnd || p < d.HeapEnd && d.FindObj(p) != ObjNil {
+				continue
+			}
+			if y := d.FindObj(p - 1); y != ObjNil {
+				r = append(r, Anomaly{x, fmt.Sprintf("%s: field %s points one past the end of object %s", d.Ft(x).Name, f.Name, d.FormatAddr(d.Addr(y)))})
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,89 @@
+package read
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+const ntPrstatus = 1 // note type of a thread's registers
+
+// Layout of the registers in the NT_PRSTATUS note of a linux core, for
+// each supported machine: the offset of pr_reg in struct
+// elf_prstatus, the number of registers, and their size.
+var prstatusRegs = map[elf.Machine]struct{ off, n, size int }{
+	elf.EM_X86_64: {112, 27, 8},
+	elf.EM_386:    {72, 17, 4},
+}
+
+// CoreRegisters returns the general purpose registers of every thread
+// in the ELF core file f, from its NT_PRSTATUS notes.  Only linux
+// amd64 and 386 cores are supported.
+func CoreRegisters(f *elf.File) ([]uint64, error) {
+	if f.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("not a core file")
+	}
+	layout, ok := prstatusRegs[f.Machine]
+	if !ok {
+		return nil, fmt.Errorf("unsupported core machine %s", f.Machine)
+	}
+	var regs []uint64
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		b := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(b, 0); err != nil {
+			return nil, err
+		}
+		// Each note is a header of three 4-byte words (name size,
+		// desc size, type), then the name and the desc, each
+		// padded to a multiple of 4 bytes.
+		for len(b) >= 12 {
+			namesz := uint64(f.ByteOrder.Uint32(b))
+			descsz := uint64(f.ByteOrder.Uint32(b[4:]))
+			typ := f.ByteOrder.Uint32(b[8:])
+			b = b[12:]
+			namesz = (namesz + 3) &^ 3
+			if namesz+descsz > uint64(len(b)) {
+				return nil, fmt.Errorf("truncated core note")
+			}
+			desc := b[namesz : namesz+descsz]
+			if typ == ntPrstatus && len(desc) >= layout.off+layout.n*layout.size {
+				regs = appendRegs(regs, desc[layout.off:], layout.n, layout.size, f.ByteOrder)
+			}
+			next := namesz + (descsz+3)&^3
+			if next > uint64(len(b)) {
+				break
+			}
+			b = b[next:]
+		}
+	}
+	return regs, nil
+}
+
+func appendRegs(regs []uint64, b []byte, n, size int, order binary.ByteOrder) []uint64 {
+	for i := 0; i < n; i++ {
+		if size == 8 {
+			regs = append(regs, order.Uint64(b[i*8:]))
+		} else {
+			regs = append(regs, uint64(order.Uint32(b[i*4:])))
+		}
+	}
+	return regs
+}
+
+// AddCoreRoots treats the objects pointed to by the registers of the
+// threads in core file f as roots (see AddRoots).  f must be a core of
+// the process which wrote the dump, taken while the process was
+// stopped in the same state.  The heap dump doesn't record registers,
+// so without this an object only a register points to, e.g. in a
+// goroutine in the middle of a syscall, looks dead.
+func (d *Dump) AddCoreRoots(f *elf.File) error {
+	regs, err := CoreRegisters(f)
+	if err != nil {
+		return err
+	}
+	d.AddRoots(regs)
+	return nil
+}
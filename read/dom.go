@@ -0,0 +1,221 @@
+package read
+
+import "sync"
+
+// ComputeDominators computes the dominator tree of the object graph
+// and the retained size of every object.  The results are cached in
+// the Dump, so this is done at most once (until InvalidateAnalysis is
+// called).  Idom and RetainedSize call it as needed; calling it
+// directly is only useful to control when the work is done.
+func (d *Dump) ComputeDominators() {
+	d.domOnce.Do(d.computeDominators)
+}
+
+func (d *Dump) computeDominators() {
+	n := d.NumObjects()
+
+	// make list of roots
+	isRoot := make([]bool, n)
+	var roots []ObjId
+	d.forEachRootEdge(func(e Edge) {
+		if !isRoot[e.To] {
+			isRoot[e.To] = true
+			roots = append(roots, e.To)
+		}
+	})
+
+	// compute postorder traversal
+	// object states:
+	// 0 - not seen yet
+	// 1 - seen, added to queue, not yet expanded children
+	// 2 - seen, already expanded children
+	// 3 - added to postorder
+	postorder := make([]ObjId, 0, n)
+	postnum := make([]int, n+1)
+	state := make([]byte, n)
+	var q []ObjId // stack of work to do, holds state 1 and 2 objects
+	for _, x := range roots {
+		if state[x] != 0 {
+			continue
+		}
+		state[x] = 1
+		q = append(q[:0], x)
+		for len(q) > 0 {
+			y := q[len(q)-1]
+			if state[y] == 2 {
+				state[y] = 3
+				q = q[:len(q)-1]
+				postnum[y] = len(postorder)
+				postorder = append(postorder, y)
+			} else {
+				state[y] = 2
+				for _, e := range d.liveEdges(y) {
+					z := e.To
+					if state[z] == 0 {
+						state[z] = 1
+						q = append(q, z)
+					}
+				}
+			}
+		}
+	}
+	postnum[n] = n // virtual start node
+
+	refidx, ref := d.liveReverseEdges()
+
+	// compute immediate dominators
+	// http://www.hipersoft.rice.edu/grads/publications/dom14.pdf
+	idom := make([]ObjId, n+1)
+	for i := 0; i < n; i++ {
+		idom[i] = ObjNil
+	}
+	idom[n] = ObjId(n)
+	for _, r := range roots {
+		idom[r] = ObjId(n)
+	}
+	change := true
+	for change {
+		change = false
+		for i := len(postorder) - 1; i >= 0; i-- {
+			x := postorder[i]
+			if isRoot[x] {
+				continue
+			}
+			a := ObjNil
+			for _, b := range ref[refidx[x]:refidx[x+1]] {
+				if idom[b] == ObjNil {
+					// unreachable, or not processed yet
+					continue
+				}
+				if a == ObjNil {
+					a = b
+					continue
+				}
+				for a != b {
+					if postnum[a] < postnum[b] {
+						a = idom[a]
+					} else {
+						b = idom[b]
+					}
+				}
+			}
+			if a != idom[x] {
+				idom[x] = a
+				change = true
+			}
+		}
+	}
+
+	domsize := make([]uint64, n+1)
+	for _, x := range postorder {
+		domsize[x] += d.Size(x)
+		domsize[idom[x]] += domsize[x]
+	}
+	// Note: unreachable objects will have domsize of 0.
+
+	d.idom = idom
+	d.domsize = domsize
+}
+
+// Idom returns the immediate dominator of x, the last object that
+// every path from the roots to x must go through.  It returns ObjNil
+// if x is pointed to directly by a root, or if x is unreachable.
+func (d *Dump) Idom(x ObjId) ObjId {
+	d.ComputeDominators()
+	y := d.idom[x]
+	if y == ObjId(d.NumObjects()) {
+		return ObjNil
+	}
+	return y
+}
+
+// RetainedSize returns the number of bytes retained by x, that is,
+// the total size of x and all the objects it dominates.  Those are
+// the objects which would be freed if x were freed.  Unreachable
+// objects retain nothing.
+func (d *Dump) RetainedSize(x ObjId) uint64 {
+	d.ComputeDominators()
+	return d.domsize[x]
+}
+
+// RetainedByType returns the number of bytes retained by all the
+// objects of the named type together (matching either the full type
+// name or the name of its Go type): the total size of the objects
+// which would be freed if none of them were reachable.  That includes
+// the objects themselves, and is often much more than the sum of
+// their RetainedSizes, since objects reachable from several of them
+// aren't dominated by any one.
+func (d *Dump) RetainedByType(typeName string) uint64 {
+	live := d.Reachable()
+	n := d.NumObjects()
+	is := func(x ObjId) bool {
+		ft := d.Ft(x)
+		return ft.Name == typeName || ft.Typ != nil && ft.Typ.Name == typeName
+	}
+
+	// find what is still reachable without going through the type
+	still := NewBitset(n)
+	var q []ObjId
+	d.forEachRootEdge(func(e Edge) {
+		if !still.Has(e.To) && !is(e.To) {
+			still.Add(e.To)
+			q = append(q, e.To)
+		}
+	})
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, e := range d.liveEdges(x) {
+			if !still.Has(e.To) && !is(e.To) {
+				still.Add(e.To)
+				q = append(q, e.To)
+			}
+		}
+	}
+
+	var total uint64
+	for _, x := range live.Objects() {
+		if !still.Has(x) {
+			total += d.Size(x)
+		}
+	}
+	return total
+}
+
+// DominatorChain returns the chain of immediate dominators of x,
+// starting with an object pointed to directly by a root and ending
+// with x itself.  Every path from the roots to x goes through all of
+// these objects, so freeing any of them frees x.  It returns nil if x
+// is unreachable.
+func (d *Dump) DominatorChain(x ObjId) []ObjId {
+	d.ComputeDominators()
+	if d.idom[x] == ObjNil {
+		return nil
+	}
+	var r []ObjId
+	for y := x; y != ObjId(d.NumObjects()); y = d.idom[y] {
+		r = append(r, y)
+	}
+	// reverse so the chain starts at the root
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r
+}
+
+// InvalidateAnalysis discards the cached results of ComputeDominators
+// and the other derived structures.  It must be called if the object
+// graph is changed.
+func (d *Dump) InvalidateAnalysis() {
+	d.liveOnce = sync.Once{}
+	d.live = nil
+	d.revOnce = sync.Once{}
+	d.revidx = nil
+	d.rev = nil
+	d.liveRevOnce = sync.Once{}
+	d.liveRevidx = nil
+	d.liveRev = nil
+	d.domOnce = sync.Once{}
+	d.idom = nil
+	d.domsize = nil
+}
@@ -0,0 +1,39 @@
+package read
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WriteEdgesJSONL writes every edge between heap objects to w in JSON
+// Lines format, one object per line:
+//
+//	{"from":"0xc208001000","to":"0xc208002000","field":"next"}
+//
+// Addresses are those of the source and target objects, in
+// hexadecimal.  The field is the name of the pointer field in the
+// source object, and is omitted if it isn't known.  Edges are written
+// as they are found, so this works for heaps whose edges wouldn't fit
+// in memory at once.
+func (d *Dump) WriteEdgesJSONL(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	for i := range d.objects {
+		x := ObjId(i)
+		for _, e := range d.Edges(x) {
+			fmt.Fprintf(b, `{"from":"0x%x","to":"0x%x"`, d.Addr(x), d.Addr(e.To))
+			if e.FieldName != "" {
+				f, err := json.Marshal(e.FieldName)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(b, `,"field":%s`, f)
+			}
+			if _, err := b.WriteString("}\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return b.Flush()
+}
@@ -0,0 +1,145 @@
+//go:build gonum
+// +build gonum
+
+// Package gonumgraph presents the object graph of a heap dump as a
+// gonum graph.Directed, so the algorithms in gonum.org/v1/gonum/graph
+// (centrality, strongly connected components, community detection,
+// ...) can be run on it.  Nodes are objects, with node ID equal to
+// the object's ObjId.
+//
+// This package depends on gonum, so it is only built with the gonum
+// build tag, to keep the rest of hprof free of dependencies.
+package gonumgraph
+
+import (
+	"github.com/randall77/hprof/read"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/iterator"
+)
+
+// Graph is a graph.Directed whose nodes are the objects of a Dump.
+type Graph struct {
+	d   *read.Dump
+	rev [][]read.ObjId // objects with an edge to each object
+}
+
+// New returns the object graph of d.
+func New(d *read.Dump) *Graph {
+	g := &Graph{d: d, rev: make([][]read.ObjId, d.NumObjects())}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := read.ObjId(i)
+		for _, e := range d.Edges(x) {
+			r := g.rev[e.To]
+			if len(r) == 0 || r[len(r)-1] != x {
+				g.rev[e.To] = append(r, x)
+			}
+		}
+	}
+	return g
+}
+
+// Node is a graph.Node representing an object.
+type Node read.ObjId
+
+func (n Node) ID() int64 { return int64(n) }
+
+// Edge is a graph.Edge representing one or more pointers from one
+// object to another.
+type Edge struct {
+	F, T Node
+}
+
+func (e Edge) From() graph.Node         { return e.F }
+func (e Edge) To() graph.Node           { return e.T }
+func (e Edge) ReversedEdge() graph.Edge { return Edge{e.T, e.F} }
+
+func (g *Graph) valid(id int64) bool {
+	return id >= 0 && id < int64(g.d.NumObjects())
+}
+
+// Node returns the node with the given ID, or nil if there is none.
+func (g *Graph) Node(id int64) graph.Node {
+	if !g.valid(id) {
+		return nil
+	}
+	return Node(id)
+}
+
+// Nodes returns all the nodes in the graph.
+func (g *Graph) Nodes() graph.Nodes {
+	return &nodeRange{cur: -1, n: int64(g.d.NumObjects())}
+}
+
+// From returns the nodes that the node with the given ID points to.
+func (g *Graph) From(id int64) graph.Nodes {
+	if !g.valid(id) {
+		return graph.Empty
+	}
+	var r []graph.Node
+	seen := map[read.ObjId]bool{}
+	for _, e := range g.d.Edges(read.ObjId(id)) {
+		if !seen[e.To] {
+			seen[e.To] = true
+			r = append(r, Node(e.To))
+		}
+	}
+	return iterator.NewOrderedNodes(r)
+}
+
+// To returns the nodes that point to the node with the given ID.
+func (g *Graph) To(id int64) graph.Nodes {
+	if !g.valid(id) {
+		return graph.Empty
+	}
+	var r []graph.Node
+	seen := map[read.ObjId]bool{}
+	for _, x := range g.rev[id] {
+		if !seen[x] {
+			seen[x] = true
+			r = append(r, Node(x))
+		}
+	}
+	return iterator.NewOrderedNodes(r)
+}
+
+// HasEdgeFromTo reports whether object uid points to object vid.
+func (g *Graph) HasEdgeFromTo(uid, vid int64) bool {
+	if !g.valid(uid) || !g.valid(vid) {
+		return false
+	}
+	for _, e := range g.d.Edges(read.ObjId(uid)) {
+		if int64(e.To) == vid {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEdgeBetween reports whether either object points to the other.
+func (g *Graph) HasEdgeBetween(xid, yid int64) bool {
+	return g.HasEdgeFromTo(xid, yid) || g.HasEdgeFromTo(yid, xid)
+}
+
+// Edge returns the edge from uid to vid, or nil if there is none.
+func (g *Graph) Edge(uid, vid int64) graph.Edge {
+	if !g.HasEdgeFromTo(uid, vid) {
+		return nil
+	}
+	return Edge{Node(uid), Node(vid)}
+}
+
+// nodeRange iterates over the nodes with IDs in [0,n).
+type nodeRange struct {
+	cur, n int64
+}
+
+func (r *nodeRange) Next() bool {
+	if r.cur+1 >= r.n {
+		return false
+	}
+	r.cur++
+	return true
+}
+func (r *nodeRange) Len() int         { return int(r.n - r.cur - 1) }
+func (r *nodeRange) Reset()           { r.cur = -1 }
+func (r *nodeRange) Node() graph.Node { return Node(r.cur) }
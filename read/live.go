@@ -0,0 +1,75 @@
+package read
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+)
+
+// WriteAndRead dumps the heap of the running program and reads the
+// result back in, so a program can inspect its own heap.  execname is
+// the running program's executable (os.Args[0] usually works), or ""
+// if the dump should be read without Dwarf naming.
+//
+// This only works on runtimes which write the go1.3 heap dump format,
+// the only one this package reads; current runtimes write a "go1.7
+// heap dump" instead, for which an error is returned.  Errors found
+// while parsing the rest of the dump are still fatal, as for Read.
+//
+// The dump is written to a temporary file using debug.WriteHeapDump.
+// WriteHeapDump stops the world until the entire heap has been
+// written, so the program is completely paused for time proportional
+// to the size of its heap.  Reading the dump back then needs memory
+// and cpu on the order of the heap size as well, as the object
+// contents are kept in memory so the file can be removed.  Don't call
+// this on a latency-sensitive path.
+func WriteAndRead(execname string) (*Dump, error) {
+	f, err := ioutil.TempFile("", "heapdump")
+	if err != nil {
+		return nil, err
+	}
+	// Runs after f is closed; removing an open file fails on Windows.
+	defer os.Remove(f.Name())
+	defer f.Close()
+	debug.WriteHeapDump(f.Fd())
+	if err := checkHeader(f); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var w *dwarf.Data
+	if execname != "" {
+		if w, err = loadDwarf(execname); err != nil {
+			return nil, err
+		}
+	}
+	d := ReadStream(f, w, nil)
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// checkHeader returns an error if the dump in r isn't in the format
+// this package reads.
+func checkHeader(r io.ReaderAt) error {
+	want := dumpVersion + " heap dump\n"
+	b := make([]byte, 64)
+	n, err := r.ReadAt(b, 0)
+	if n < len(want) && err != nil {
+		return err
+	}
+	b = b[:n]
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i+1]
+	}
+	if string(b) != want {
+		return fmt.Errorf("heap dump has header %q, want %q", b, want)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package read
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A MapEntry is one key/value pair stored in a map.  Key and Value
+// are the raw contents of the key and value slots.  Keys and values
+// which are too big to be stored in the bucket are stored indirectly,
+// in which case the slot holds just a pointer.  KeyEdges and
+// ValueEdges are the edges out of the two slots.
+type MapEntry struct {
+	Bucket                 ObjId  // bucket object holding the entry
+	KeyOffset, ValueOffset uint64 // offsets of the slots in Bucket
+	Key, Value             []byte
+	KeyEdges, ValueEdges   []Edge
+}
+
+const (
+	bucketCnt  = 8 // slots per bucket
+	minTopHash = 4 // smaller tophash values mark empty or evacuated slots
+)
+
+// bucketLayout describes a map bucket type, as found in its Dwarf
+// fields.
+type bucketLayout struct {
+	size              uint64
+	tophash           uint64
+	keys, keySize     uint64
+	values, valueSize uint64
+	overflow          uint64
+}
+
+// slot returns the offset of the first slot of the array field named
+// prefix in fields and the distance between slots.  It returns false
+// if the slots have no fields (e.g. struct{} values).
+func slot(fields []Field, prefix string) (off, size uint64, ok bool) {
+	var offs [2]uint64
+	var found [2]bool
+	for _, f := range fields {
+		for i := range offs {
+			n := fmt.Sprintf("%s.%d", prefix, i)
+			if f.Name != n && !strings.HasPrefix(f.Name, n+".") {
+				continue
+			}
+			if !found[i] || f.Offset < offs[i] {
+				offs[i] = f.Offset
+				found[i] = true
+			}
+		}
+	}
+	if !found[0] || !found[1] {
+		return 0, 0, false
+	}
+	return offs[0], offs[1] - offs[0], true
+}
+
+// layoutOf returns the layout of bucket type t.  It needs the field
+// names from the Dwarf info.
+func layoutOf(t *Type) (bucketLayout, bool) {
+	l := bucketLayout{size: t.Size}
+	th, _, ok := slot(t.Fields, "tophash")
+	if !ok {
+		return l, false
+	}
+	l.tophash = th
+	l.keys, l.keySize, ok = slot(t.Fields, "keys")
+	if !ok {
+		return l, false
+	}
+	l.values, l.valueSize, _ = slot(t.Fields, "values")
+	for _, f := range t.Fields {
+		if f.Name == "overflow" {
+			l.overflow = f.Offset
+			return l, true
+		}
+	}
+	return l, false
+}
+
+// MapEntries returns the entries of the map whose header is object x,
+// skipping empty slots.  Entries in buckets which are still being
+// evacuated to a grown bucket array are included.  It returns nil if
+// x is not a map header, if the dump was read without Dwarf info (the
+// bucket layout comes from the Dwarf field names), or if the contents
+// have been dropped.
+func (d *Dump) MapEntries(x ObjId) []MapEntry {
+	ft := d.Ft(x)
+	if ft.Typ == nil || !strings.HasPrefix(ft.Typ.Name, "map.hdr[") || !d.HasContents() {
+		return nil
+	}
+	var r []MapEntry
+	for _, name := range []string{"buckets", "oldbuckets"} {
+		e, ok := d.EdgeByField(x, name)
+		if !ok {
+			continue
+		}
+		b := e.To
+		t := d.Ft(b).Typ
+		if t == nil || t.Size == 0 {
+			continue
+		}
+		l, ok := layoutOf(t)
+		if !ok {
+			continue
+		}
+		// The bucket array can be huge, so read it only once.
+		data, edges := d.Contents(b), d.Edges(b)
+		for off := e.ToOffset; off+t.Size <= d.Size(b); off += t.Size {
+			r = d.appendBucketChain(r, b, data, edges, off, l)
+		}
+	}
+	return r
+}
+
+// appendBucketChain appends to r the entries in the bucket at offset
+// off in object b, whose contents and edges are data and edges, and in
+// its chain of overflow buckets.
+func (d *Dump) appendBucketChain(r []MapEntry, b ObjId, data []byte, edges []Edge, off uint64, l bucketLayout) []MapEntry {
+	seen := map[ObjId]bool{}
+	for {
+		for i := uint64(0); i < bucketCnt; i++ {
+			if data[off+l.tophash+i] < minTopHash {
+				continue
+			}
+			ko := off + l.keys + i*l.keySize
+			vo := off + l.values + i*l.valueSize
+			r = append(r, MapEntry{
+				Bucket:      b,
+				KeyOffset:   ko,
+				ValueOffset: vo,
+				Key:         data[ko : ko+l.keySize],
+				Value:       data[vo : vo+l.valueSize],
+				KeyEdges:    edgesIn(edges, ko, l.keySize),
+				ValueEdges:  edgesIn(edges, vo, l.valueSize),
+			})
+		}
+		// Follow the overflow pointer.  Overflow buckets are
+		// allocated one at a time, so they start at offset 0.
+		y := d.FindObj(readPtr(d, data[off+l.overflow:]))
+		if y == ObjNil || seen[y] || d.Size(y) < l.size {
+			return r
+		}
+		seen[y] = true
+		b, off = y, 0
+		data, edges = d.Contents(b), d.Edges(b)
+	}
+}
+
+// edgesIn returns the edges in edges whose source is in [off,off+size).
+// edges must be sorted by source offset, as Edges returns them.
+func edgesIn(edges []Edge, off, size uint64) []Edge {
+	i := sort.Search(len(edges), func(i int) bool { return edges[i].FromOffset >= off })
+	j := i
+	for j < len(edges) && edges[j].FromOffset < off+size {
+		j++
+	}
+	if i == j {
+		return nil
+	}
+	return edges[i:j:j]
+}
+
+// A MapStat describes the bucket array of a map.
+type MapStat struct {
+	Map        ObjId  // map header
+	Buckets    ObjId  // bucket array
+	NumBuckets uint64 // buckets in the array
+	Bytes      uint64 // size of the bucket array
+	Entries    uint64 // most entries the buckets hold before growing
+}
+
+// LargeMaps returns the n maps with the largest bucket arrays, largest
+// first.  Entries is estimated from the number of buckets: the runtime
+// grows a map when it averages 6.5 entries per bucket, so a map which
+// hasn't had many entries deleted holds between half and all of that.
+// Unlike MapEntries this only needs the bucket size, not the bucket
+// layout, but like it, it needs the type names from the Dwarf info.
+// Overflow buckets and buckets being evacuated are not counted.
+func (d *Dump) LargeMaps(n int) []MapStat {
+	var r []MapStat
+	for i := range d.objects {
+		x := ObjId(i)
+		ft := d.objects[i].Ft
+		if ft.Typ == nil || !strings.HasPrefix(ft.Typ.Name, "map.hdr[") {
+			continue
+		}
+		e, ok := d.EdgeByField(x, "buckets")
+		if !ok {
+			continue
+		}
+		t := d.Ft(e.To).Typ
+		if t == nil || t.Size == 0 {
+			continue
+		}
+		nb := (d.Size(e.To) - e.ToOffset) / t.Size
+		r = append(r, MapStat{
+			Map:        x,
+			Buckets:    e.To,
+			NumBuckets: nb,
+			Bytes:      d.Size(e.To),
+			Entries:    nb * bucketCnt * 13 / 16, // load factor 6.5
+		})
+	}
+	sort.Sort(byMapStatBytes(r))
+	if n >= 0 && len(r) > n {
+		r = r[:n]
+	}
+	return r
+}
+
+type byMapStatBytes []MapStat
+
+func (a byMapStatBytes) Len() int           { return len(a) }
+func (a byMapStatBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byMapStatBytes) Less(i, j int) bool { return a[i].Bytes > a[j].Bytes }
@@ -2,6 +2,7 @@ package read
 
 import (
 	"bufio"
+	"bytes"
 	"debug/dwarf"
 	"debug/elf"
 	"debug/macho"
@@ -15,8 +16,18 @@ import (
 	"regexp"
 	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
 )
 
+// Logger is used for all of this package's diagnostics, including
+// fatal errors.  It is the standard logger by default, so it follows
+// log.SetOutput and log.SetFlags; programs which embed this package
+// can replace it to redirect or silence just its output.
+var Logger = log.Default()
+
 type FieldKind int
 type TypeKind int
 
@@ -74,6 +85,7 @@ const (
 	dw_op_call_frame_cfa = 156
 	dw_op_consts         = 17
 	dw_op_plus           = 34
+	dw_op_plus_uconst    = 35
 	dw_op_addr           = 3
 	dw_ate_boolean       = 2
 	dw_ate_complex_float = 3 // complex64/complex128
@@ -86,16 +98,28 @@ const (
 	// of the total heap size and require us to look at at most
 	// 64 objects.
 	bucketSize = 512
+
+	// Minimum number of bytes read between calls to Options.Progress.
+	minProgressStep = 1 << 20
+
+	// Version of the dump format this package reads, from the
+	// "go1.3 heap dump" header line.
+	dumpVersion = "go1.3"
 )
 
+// A Dump is a parsed heap dump.  Once Read has returned, a Dump may be
+// queried by multiple goroutines concurrently; derived structures like
+// the dominator tree are built at most once, by whichever query needs
+// them first.  Modifying a Dump, or calling InvalidateAnalysis, must
+// not happen concurrently with anything else.
 type Dump struct {
 	Order        binary.ByteOrder
 	PtrSize      uint64 // in bytes
 	HChanSize    uint64 // channel header size in bytes
 	HeapStart    uint64
 	HeapEnd      uint64
-	TheChar      byte
-	Experiment   string
+	TheChar      byte   // architecture character, see Arch
+	Experiment   string // comma-separated list of GOEXPERIMENTs, see Experiments
 	Ncpu         uint64
 	Types        []*Type
 	objects      []object
@@ -113,12 +137,36 @@ type Dump struct {
 	MemProf      []*MemProfEntry
 	AllocSamples []*AllocSample
 
-	// handle to dump file
-	r io.ReaderAt
+	// conservative is set if objects with no type are scanned
+	// conservatively by the liveness analyses, see
+	// Options.ConservativeUntyped.
+	conservative bool
+
+	// nonRetaining[id] is set if the objects of the full type with
+	// that id are ignored by the liveness analyses, see
+	// SetNonRetainingTypes.
+	nonRetaining []bool
+
+	// normalized is set by NormalizeAddresses.
+	normalized bool
+
+	// format version from the header line, see FormatVersion.
+	version string
+
+	// most recent WaitSince of any goroutine, see WaitDuration.
+	latestWait uint64
 
-	buf []byte // temporary space for Contents calls
+	// extracted is set for dumps made from part of another dump
+	// (see Subgraph), whose Memstats describe the whole heap.
+	extracted bool
 
-	edges []Edge // temporary space for Edges calls
+	// Truncated is set if the dump ended without an EOF record,
+	// e.g. because the program crashed while writing it.  The Dump
+	// then contains only the records before the end.
+	Truncated bool
+
+	// handle to dump file
+	r io.ReaderAt
 
 	// list of full types, indexed by ID
 	FTList []*FullType
@@ -136,6 +184,43 @@ type Dump struct {
 	// bytes in that bucket.
 	bucketSize uint64
 	idx        []ObjId
+
+	// object contents replaced by SetContents
+	patched map[ObjId][]byte
+
+	// all edges, in the same compressed form as reverseEdges.  Only
+	// set once the object contents have been dropped, see
+	// Options.DropDataAfterLink.
+	edgeidx []int
+	edgeref []Edge
+
+	// objects reachable from the roots, see IsReachable.
+	liveOnce sync.Once
+	live     Bitset
+
+	// reverse edges, see reverseEdges.
+	revOnce sync.Once
+	revidx  []int
+	rev     []ObjId
+
+	// reverse edges which retain something, see liveReverseEdges.
+	liveRevOnce sync.Once
+	liveRevidx  []int
+	liveRev     []ObjId
+
+	// dominator tree and retained sizes, see ComputeDominators.
+	// Indexed by ObjId, plus an entry for a virtual root at the end.
+	domOnce sync.Once
+	idom    []ObjId
+	domsize []uint64
+
+	// full types and object contents of a Dump being built, see
+	// NewDump.
+	ftmap map[tkey]*FullType
+	mem   []byte
+
+	// object counts by full type id, see ReadTypeHistogram.
+	histo []TypeStat
 }
 
 type Type struct {
@@ -145,6 +230,65 @@ type Type struct {
 	Fields   []Field // ordered in increasing offset order
 
 	Addr uint64
+
+	dumpFields []Field // fields as recorded in the dump, before Dwarf naming
+}
+
+// EfaceDataIsPointer reports whether the data word of an interface{}
+// holding a value of this type is a pointer.  It is true for
+// pointer-shaped types, which are stored directly in the data word,
+// and for types too big for the data word, which are boxed.  It is
+// false for small scalars stored directly in the data word.
+func (t *Type) EfaceDataIsPointer() bool {
+	return t.efaceptr
+}
+
+// IfaceDataIsPointer reports whether the data word of a non-empty
+// interface with the given itab is a pointer.  The second result is
+// false if the itab is not in the dump.
+func (d *Dump) IfaceDataIsPointer(itab uint64) (bool, bool) {
+	ptr, ok := d.ItabMap[itab]
+	return ptr, ok
+}
+
+// TypesByName returns the types in the dump sorted by name.  Types
+// with the same name are sorted by address.  Like d.Types, the result
+// has no duplicates: the dump can contain several records for a type,
+// but only the first one is kept.
+func (d *Dump) TypesByName() []*Type {
+	r := append([]*Type(nil), d.Types...)
+	sort.Sort(typesByName(r))
+	return r
+}
+
+// TypesBySize returns the types in the dump sorted by decreasing size.
+// Types with the same size are sorted by name.
+func (d *Dump) TypesBySize() []*Type {
+	r := append([]*Type(nil), d.Types...)
+	sort.Sort(typesBySize(r))
+	return r
+}
+
+type typesByName []*Type
+
+func (a typesByName) Len() int      { return len(a) }
+func (a typesByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a typesByName) Less(i, j int) bool {
+	if a[i].Name != a[j].Name {
+		return a[i].Name < a[j].Name
+	}
+	return a[i].Addr < a[j].Addr
+}
+
+type typesBySize []*Type
+
+func (a typesBySize) Len() int      { return len(a) }
+func (a typesBySize) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a typesBySize) Less(i, j int) bool {
+	if a[i].Size != a[j].Size {
+		return a[i].Size > a[j].Size
+	}
+	return typesByName(a).Less(i, j)
 }
 
 type FullType struct {
@@ -176,6 +320,10 @@ type object struct {
 	Addr   uint64
 }
 
+// An ObjId identifies an object in a Dump.  ObjIds are dense: they
+// run from 0 to NumObjects()-1, in increasing address order, and don't
+// change once the Dump has been read.  So they can be used directly as
+// indexes into slices (or bitsets) holding per-object data.
 type ObjId int
 
 const (
@@ -187,18 +335,60 @@ const (
 func (d *Dump) NumObjects() int {
 	return len(d.objects)
 }
+
+// Contents returns the contents of object i.  The result belongs to
+// the caller.  It returns nil if the contents have been dropped (see
+// Options.DropDataAfterLink).
 func (d *Dump) Contents(i ObjId) []byte {
+	return d.readContents(nil, i)
+}
+
+// HasContents reports whether object contents are available.
+func (d *Dump) HasContents() bool {
+	return d.r != nil
+}
+
+// SetContents replaces the contents of object x with b, which must be
+// the size of x.  Edges (and everything computed from them) reflect
+// the new contents from then on, so a tool can ask "what if this
+// field were nil?" by clearing the field and then looking at what is
+// still reachable.  The dump file is not modified.  An error is
+// returned, and nothing changed, if b is the wrong size.  Like
+// AddRoots, SetContents modifies d, so it must not be called
+// concurrently with anything else.
+func (d *Dump) SetContents(x ObjId, b []byte) error {
+	if uint64(len(b)) != d.Size(x) {
+		return fmt.Errorf("SetContents: object %d has size %d, contents have size %d", x, d.Size(x), len(b))
+	}
+	if d.patched == nil {
+		d.patched = map[ObjId][]byte{}
+	}
+	d.patched[x] = append([]byte(nil), b...)
+	d.InvalidateAnalysis()
+	return nil
+}
+
+// scratch space for Edges calls
+var contentsPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// readContents reads the contents of object i into b, which is grown
+// if it is too small, and returns the result.
+func (d *Dump) readContents(b []byte, i ObjId) []byte {
+	if p, ok := d.patched[i]; ok {
+		return append(b[:0], p...)
+	}
+	if d.r == nil {
+		return nil
+	}
 	x := d.objects[i]
-	b := d.buf
 	if uint64(cap(b)) < x.Ft.Size {
 		b = make([]byte, x.Ft.Size)
-		d.buf = b
 	}
 	b = b[:x.Ft.Size]
 	n, err := d.r.ReadAt(b, x.offset)
 	if err != nil && !(n == len(b) && err == io.EOF) {
 		// TODO: propagate to caller
-		log.Fatal(err)
+		Logger.Fatal(err)
 	}
 	return b
 }
@@ -212,6 +402,64 @@ func (d *Dump) Ft(x ObjId) *FullType {
 	return d.objects[x].Ft
 }
 
+// Architectures, indexed by the architecture character in the
+// params record, and the pointer size for each.
+var archs = map[byte]struct {
+	name    string
+	ptrSize uint64
+	order   binary.ByteOrder // nil if it can be either
+}{
+	'5': {"arm", 4, binary.LittleEndian},
+	'6': {"amd64", 8, binary.LittleEndian},
+	'7': {"arm64", 8, binary.LittleEndian},
+	'8': {"386", 4, binary.LittleEndian},
+	'9': {"ppc64", 8, nil}, // also ppc64le
+}
+
+// Arch returns the name of the architecture the dump was taken on,
+// e.g. "amd64", or "" if it is not known.
+func (d *Dump) Arch() string {
+	return archs[d.TheChar].name
+}
+
+// FormatVersion returns the version of the dump format, from the
+// header line of the dump, e.g. "go1.3".
+func (d *Dump) FormatVersion() string {
+	return d.version
+}
+
+// NumCPU returns the number of cpus of the machine the dump was
+// taken on.
+func (d *Dump) NumCPU() uint64 {
+	return d.Ncpu
+}
+
+// Experiments returns the list of GOEXPERIMENTs enabled in the
+// runtime that wrote the dump.
+func (d *Dump) Experiments() []string {
+	var r []string
+	for _, e := range strings.Split(d.Experiment, ",") {
+		if e != "" {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// IsNativeByteOrder reports whether the dump has the same byte order
+// as the machine running this code.
+func (d *Dump) IsNativeByteOrder() bool {
+	x := uint16(1)
+	little := *(*byte)(unsafe.Pointer(&x)) == 1
+	return little == (d.Order == binary.LittleEndian)
+}
+
+// IsNativePointerSize reports whether the dump has the same pointer
+// size as the machine running this code.
+func (d *Dump) IsNativePointerSize() bool {
+	return d.PtrSize == uint64(unsafe.Sizeof(uintptr(0)))
+}
+
 // FindObj returns the object id containing the address addr, or -1 if no object contains addr.
 func (d *Dump) FindObj(addr uint64) ObjId {
 	if addr < d.HeapStart || addr >= d.HeapEnd { // quick exit.  Includes nil.
@@ -230,10 +478,36 @@ func (d *Dump) FindObj(addr uint64) ObjId {
 	return ObjNil
 }
 
+// Edges returns the pointers from object i to other heap objects.
+// The result belongs to the caller.
 func (d *Dump) Edges(i ObjId) []Edge {
+	e := d.edges(i)
+	if d.indexed(i) {
+		e = append([]Edge(nil), e...)
+	}
+	return e
+}
+
+// indexed reports whether the edges of object i come from the edge
+// index kept by Options.DropDataAfterLink, and so are shared.
+func (d *Dump) indexed(i ObjId) bool {
+	_, ok := d.patched[i]
+	return d.edgeidx != nil && !ok
+}
+
+// edges is like Edges, but returns the edge index itself if there is
+// one rather than a copy, so callers must not modify the result.  It
+// is for the loops which look at the edges of every object.
+func (d *Dump) edges(i ObjId) []Edge {
+	if d.indexed(i) {
+		return d.edgeref[d.edgeidx[i]:d.edgeidx[i+1]]
+	}
 	x := &d.objects[i]
-	e := d.edges[:0]
-	b := d.Contents(i)
+	var e []Edge
+	bp := contentsPool.Get().(*[]byte)
+	b := d.readContents(*bp, i)
+	*bp = b
+	defer contentsPool.Put(bp)
 	for _, f := range x.Ft.Fields {
 		switch f.Kind {
 		case FieldKindPtr, FieldKindString, FieldKindSlice:
@@ -243,13 +517,23 @@ func (d *Dump) Edges(i ObjId) []Edge {
 				e = append(e, Edge{y, f.Offset, p - d.objects[y].Addr, f.Name})
 			}
 		case FieldKindEface:
+			// An interface is a type (or itab) word followed by a
+			// data word.  The type word is a pointer, although it
+			// rarely points into the heap.  The data word holds
+			// either the value itself, if it fits, or a pointer
+			// to a boxed copy of it; the dump records which for
+			// each type.  Either way, a data word holding a pointer
+			// is one edge, not two.
 			taddr := readPtr(d, b[f.Offset:])
+			if y := d.FindObj(taddr); y != ObjNil {
+				e = append(e, Edge{y, f.Offset, taddr - d.objects[y].Addr, f.Name})
+			}
 			if taddr != 0 {
 				t := d.TypeMap[taddr]
 				if t == nil {
-					log.Fatal("can't find eface type", taddr)
+					Logger.Fatal("can't find eface type", taddr)
 				}
-				if t.efaceptr {
+				if t.EfaceDataIsPointer() {
 					p := readPtr(d, b[f.Offset+d.PtrSize:])
 					y := d.FindObj(p)
 					if y != ObjNil {
@@ -259,10 +543,13 @@ func (d *Dump) Edges(i ObjId) []Edge {
 			}
 		case FieldKindIface:
 			itabaddr := readPtr(d, b[f.Offset:])
+			if y := d.FindObj(itabaddr); y != ObjNil {
+				e = append(e, Edge{y, f.Offset, itabaddr - d.objects[y].Addr, f.Name})
+			}
 			if itabaddr != 0 {
-				ptr, ok := d.ItabMap[itabaddr]
+				ptr, ok := d.IfaceDataIsPointer(itabaddr)
 				if !ok {
-					log.Fatal("can't find itab", itabaddr)
+					Logger.Fatal("can't find itab", itabaddr)
 				}
 				if ptr {
 					p := readPtr(d, b[f.Offset+d.PtrSize:])
@@ -276,15 +563,117 @@ func (d *Dump) Edges(i ObjId) []Edge {
 			continue
 		}
 	}
-	d.edges = e
 	return e
 }
 
+// A SliceHeader is a slice stored in an object, along with the heap
+// object holding its backing array.  The slice may use only part of
+// that object, but the garbage collector can only free whole objects,
+// so a small slice of a big array keeps the whole array alive.
+// Comparing Size(Backing) with UsedBytes shows how much is held for
+// nothing.
+type SliceHeader struct {
+	Ptr, Len, Cap uint64
+	Backing       ObjId  // object containing Ptr, or ObjNil if not in the heap
+	Offset        uint64 // offset of Ptr in Backing
+	ElemSize      uint64 // size of an element, 0 if not known
+}
+
+// UsedBytes returns the number of bytes of the backing array in
+// [0,len) of the slice, or 0 if the element size is not known.
+func (s SliceHeader) UsedBytes() uint64 {
+	return s.Len * s.ElemSize
+}
+
+// CapBytes returns the number of bytes of the backing array in
+// [0,cap) of the slice, or 0 if the element size is not known.
+func (s SliceHeader) CapBytes() uint64 {
+	return s.Cap * s.ElemSize
+}
+
+// SliceAt returns the slice header stored at offset off in object x.
+// The element size is known if the backing object is an array of a
+// known type.  If the contents of x are not available, or there is no
+// room for a slice header at off, Backing is ObjNil and the rest is
+// zero.
+func (d *Dump) SliceAt(x ObjId, off uint64) SliceHeader {
+	s := SliceHeader{Backing: ObjNil}
+	b := d.Contents(x)
+	if b == nil || off > uint64(len(b)) || uint64(len(b))-off < 3*d.PtrSize {
+		return s
+	}
+	b = b[off:]
+	s.Ptr = readPtr(d, b)
+	s.Len = readPtr(d, b[d.PtrSize:])
+	s.Cap = readPtr(d, b[2*d.PtrSize:])
+	s.Backing = d.FindObj(s.Ptr)
+	if s.Backing != ObjNil {
+		s.Offset = s.Ptr - d.Addr(s.Backing)
+		if ft := d.Ft(s.Backing); ft.Kind == TypeKindArray && ft.Typ != nil {
+			s.ElemSize = ft.Typ.Size
+		}
+	}
+	return s
+}
+
+// EdgeByField returns the first edge out of object x whose field name
+// is name.  It returns false if there is no such edge, either because
+// there is no such field or because the field is nil or doesn't point
+// into the heap.
+func (d *Dump) EdgeByField(x ObjId, name string) (Edge, bool) {
+	for _, e := range d.Edges(x) {
+		if e.FieldName == name {
+			return e, true
+		}
+	}
+	return Edge{}, false
+}
+
 type OtherRoot struct {
 	Description string
 	Edges       []Edge
 
-	toaddr uint64
+	toaddr    uint64
+	synthetic bool // added by Subgraph, RetentionGraph, or AddRoots
+}
+
+// OtherRootKind classifies OtherRoots by what is keeping the object
+// alive.  The runtime keeps most roots in records of their own
+// (StackFrame, Data, Finalizer, ...), so there are few kinds.
+type OtherRootKind int
+
+const (
+	OtherRootUnknown   OtherRootKind = iota
+	OtherRootTypeInfo                // type information of a span
+	OtherRootSynthetic               // added by Subgraph, RetentionGraph, or AddRoots
+)
+
+var otherRootKindNames = [...]string{
+	OtherRootUnknown:   "unknown",
+	OtherRootTypeInfo:  "type info",
+	OtherRootSynthetic: "synthetic",
+}
+
+func (k OtherRootKind) String() string {
+	if k < 0 || int(k) >= len(otherRootKindNames) {
+		return fmt.Sprintf("OtherRootKind(%d)", int(k))
+	}
+	return otherRootKindNames[k]
+}
+
+// otherRootKinds maps the descriptions the runtime gives other roots
+// to their kind.
+var otherRootKinds = map[string]OtherRootKind{
+	"runtime type info": OtherRootTypeInfo, // MSpan.types
+}
+
+// Kind classifies the root.  A description the runtime doesn't write
+// is OtherRootUnknown.
+func (r *OtherRoot) Kind() OtherRootKind {
+	if r.synthetic {
+		return OtherRootSynthetic
+	}
+	return otherRootKinds[r.Description]
 }
 
 // Object obj has a finalizer.
@@ -349,6 +738,8 @@ type Data struct {
 	Data   []byte
 	Fields []Field
 	Edges  []Edge
+
+	dumpFields []Field // fields as recorded in the dump, before Dwarf naming
 }
 
 type OSThread struct {
@@ -368,7 +759,12 @@ type Field struct {
 
 type GoRoutine struct {
 	Bos  *StackFrame // frame at the top of the stack (i.e. currently running)
-	Ctxt ObjId
+	Ctxt ObjId       // context object (closure, receiver, ...), or ObjNil if none
+
+	// Edges to objects held by the goroutine itself rather than
+	// by its stack frames: the context object and objects used by
+	// the goroutine's pending defers and panics.
+	Edges []Edge
 
 	Addr         uint64
 	bosaddr      uint64
@@ -377,7 +773,7 @@ type GoRoutine struct {
 	Status       uint64
 	IsSystem     bool
 	IsBackground bool
-	WaitSince    uint64
+	WaitSince    uint64 // runtime nanotime when the goroutine was first seen blocked, 0 if unknown
 	WaitReason   string
 	ctxtaddr     uint64
 	maddr        uint64
@@ -385,6 +781,61 @@ type GoRoutine struct {
 	panicaddr    uint64
 }
 
+// StatusString returns a description of the goroutine's scheduling
+// state, e.g. "runnable".  For waiting goroutines it is the reason
+// they are waiting, e.g. "chan receive".
+func (g *GoRoutine) StatusString() string {
+	switch g.Status {
+	case 0:
+		return "idle"
+	case 1:
+		return "runnable"
+	case 2:
+		return "running"
+	case 3:
+		return "syscall"
+	case 4:
+		return g.WaitReason
+	case 5:
+		return "dead"
+	}
+	return fmt.Sprintf("status %d", g.Status)
+}
+
+// LocalObject returns the object pointed to by the local variable or
+// argument varName of the innermost call to funcName (e.g.
+// "main.serve") on g's stack.  For variables holding several pointers
+// (structs, strings, slices, ...) it returns the first one; use a
+// field path like "req.body" to pick another.  It returns ObjNil if
+// there is no such frame or variable, or if the variable doesn't point
+// into the heap.
+func (g *GoRoutine) LocalObject(funcName, varName string) ObjId {
+	for f := g.Bos; f != nil; f = f.Parent {
+		if f.Name != funcName {
+			continue
+		}
+		for _, e := range f.Edges {
+			if e.FieldName == varName || strings.HasPrefix(e.FieldName, varName+".") {
+				return e.To
+			}
+		}
+		return ObjNil
+	}
+	return ObjNil
+}
+
+// WaitDuration returns how long the goroutine g had been blocked when
+// the dump was taken.  WaitSince is on the runtime's monotonic clock,
+// which the dump doesn't otherwise record, so the duration is measured
+// from the most recent WaitSince of any goroutine and is only a lower
+// bound.  It returns false if g's wait time is not known.
+func (d *Dump) WaitDuration(g *GoRoutine) (time.Duration, bool) {
+	if g.WaitSince == 0 {
+		return 0, false
+	}
+	return time.Duration(d.latestWait - g.WaitSince), true
+}
+
 type StackFrame struct {
 	Name      string
 	Parent    *StackFrame
@@ -406,10 +857,22 @@ type Reader interface {
 	ReadByte() (c byte, err error)
 }
 
+// truncatedError is panicked by the read functions when the dump
+// ends in the middle of a record.  rawReadFrom recovers it.
+type truncatedError struct{}
+
+// readError reports an error reading the dump.
+func readError(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		panic(truncatedError{})
+	}
+	Logger.Fatal(err)
+}
+
 func readUint64(r Reader) uint64 {
 	x, err := binary.ReadUvarint(r)
 	if err != nil {
-		log.Fatal(err)
+		readError(err)
 	}
 	return x
 }
@@ -418,7 +881,7 @@ func readNBytes(r Reader, n uint64) []byte {
 	s := make([]byte, n)
 	_, err := io.ReadFull(r, s)
 	if err != nil {
-		log.Fatal(err)
+		readError(err)
 	}
 	return s
 }
@@ -435,7 +898,7 @@ func readString(r Reader) string {
 func readBool(r Reader) bool {
 	b, err := r.ReadByte()
 	if err != nil {
-		log.Fatal(err)
+		readError(err)
 	}
 	return b != 0
 }
@@ -485,6 +948,26 @@ func (r *myReader) Count() int64 {
 	return r.cnt
 }
 
+// skipJunk skips a UTF-8 byte order mark and any white space, which
+// some setups that redirect the dump put before the header.
+func (r *myReader) skipJunk() {
+	if b, _ := r.r.Peek(3); string(b) == "\xef\xbb\xbf" {
+		r.Skip(3)
+	}
+	for {
+		b, err := r.r.Peek(1)
+		if err != nil {
+			return
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return
+		}
+	}
+}
+
 type tkey struct {
 	typaddr uint64
 	kind    TypeKind
@@ -494,282 +977,391 @@ type tkey struct {
 func (d *Dump) makeFullType(typaddr uint64, kind TypeKind, size uint64) *FullType {
 	t := d.TypeMap[typaddr]
 	if typaddr != 0 && t == nil {
-		log.Fatal("types appear before use of that type")
+		Logger.Fatal("types appear before use of that type")
 	}
-	var name string
-	switch kind {
+	// Note: the name is filled in by nameFullTypes once the whole dump
+	// has been read, as it may depend on the params record.
+	ft := &FullType{len(d.FTList), t, kind, size, "", nil}
+	d.FTList = append(d.FTList, ft)
+	return ft
+}
+
+func (d *Dump) nameFullType(ft *FullType) {
+	t := ft.Typ
+	size := ft.Size
+	switch ft.Kind {
 	case TypeKindObject:
 		if t != nil {
-			name = t.Name
+			ft.Name = t.Name
 		} else {
-			name = fmt.Sprintf("noptr%d", size)
+			ft.Name = fmt.Sprintf("noptr%d", size)
 		}
 	case TypeKindArray:
-		name = fmt.Sprintf("{%d}%s", size/t.Size, t.Name)
+		ft.Name = fmt.Sprintf("{%d}%s", size/t.Size, t.Name)
 	case TypeKindChan:
-		if d.HChanSize == 0 {
-			log.Fatal("hchansize must be before objects")
-		}
 		if t.Size > 0 {
-			name = fmt.Sprintf("chan{%d}%s", (size-d.HChanSize)/t.Size, t.Name)
+			ft.Name = fmt.Sprintf("chan{%d}%s", (size-d.HChanSize)/t.Size, t.Name)
 		} else {
-			name = fmt.Sprintf("chan{inf}%s", t.Name)
+			ft.Name = fmt.Sprintf("chan{inf}%s", t.Name)
 		}
 	case TypeKindConservative:
-		name = fmt.Sprintf("conservative%d", size)
+		ft.Name = fmt.Sprintf("conservative%d", size)
 	}
-	ft := &FullType{len(d.FTList), t, kind, size, name, nil}
-	d.FTList = append(d.FTList, ft)
-	return ft
 }
 
 // Reads heap dump into memory.
-func rawRead(filename string) *Dump {
+func rawRead(filename string, opts *Options) *Dump {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		Logger.Fatal(err)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		Logger.Fatal(err)
+	}
+	return rawReadFrom(file, file, fi.Size(), opts)
+}
+
+// rawReadFrom reads a heap dump sequentially from rd.  If ra is not
+// nil, it must provide random access to the same data as rd, and
+// object contents are read from it on demand.  Otherwise, object
+// contents are kept in memory.  total is the size of the dump, or 0
+// if not known.
+func rawReadFrom(rd io.Reader, ra io.ReaderAt, total int64, opts *Options) *Dump {
+	r := &myReader{r: bufio.NewReader(rd)}
+
+	// progress reporting, about every 1% of the file
+	var step, next int64
+	if opts.Progress != nil {
+		step = total / 100
+		if step < minProgressStep {
+			step = minProgressStep
+		}
 	}
-	r := &myReader{r: bufio.NewReader(file)}
 
 	// check for header
+	r.skipJunk()
 	hdr, prefix, err := r.ReadLine()
 	if err != nil {
-		log.Fatal(err)
+		Logger.Fatal(err)
 	}
-	if prefix || string(hdr) != "go1.3 heap dump" {
-		log.Fatal("not a go1.3 heap dump file")
+	version := strings.TrimSuffix(string(bytes.TrimSpace(hdr)), " heap dump")
+	if prefix || version != dumpVersion {
+		Logger.Fatalf("not a %s heap dump file", dumpVersion)
 	}
 
 	var d Dump
-	d.r = file
+	d.version = version
+	d.r = ra
+	var mem []byte // object contents, if there is no ra
 	d.ItabMap = map[uint64]bool{}
 	d.TypeMap = map[uint64]*Type{}
 	ftmap := map[tkey]*FullType{} // full type dedup
 	memprof := map[uint64]*MemProfEntry{}
-	for {
-		kind := readUint64(r)
-		switch kind {
-		case tagObject:
-			obj := object{}
-			obj.Addr = readUint64(r)
-			typaddr := readUint64(r)
-			kind := TypeKind(readUint64(r))
-			size := readUint64(r)
-			k := tkey{typaddr, kind, size}
-			ft := ftmap[k]
-			if ft == nil {
-				ft = d.makeFullType(typaddr, kind, size)
-				ftmap[k] = ft
-			}
-			obj.Ft = ft
-			obj.offset = r.Count()
-			r.Skip(int64(ft.Size))
-			d.objects = append(d.objects, obj)
-		case tagEOF:
-			return &d
-		case tagOtherRoot:
-			t := &OtherRoot{}
-			t.Description = readString(r)
-			t.toaddr = readUint64(r)
-			d.Otherroots = append(d.Otherroots, t)
-		case tagType:
-			typ := &Type{}
-			typ.Addr = readUint64(r)
-			typ.Size = readUint64(r)
-			typ.Name = readString(r)
-			typ.efaceptr = readBool(r)
-			typ.Fields = readFields(r)
-			// Note: there may be duplicate type records in a dump.
-			// The duplicates get thrown away here.
-			if _, ok := d.TypeMap[typ.Addr]; !ok {
-				d.TypeMap[typ.Addr] = typ
-				d.Types = append(d.Types, typ)
-			}
-		case tagGoRoutine:
-			g := &GoRoutine{}
-			g.Addr = readUint64(r)
-			g.bosaddr = readUint64(r)
-			g.Goid = readUint64(r)
-			g.Gopc = readUint64(r)
-			g.Status = readUint64(r)
-			g.IsSystem = readBool(r)
-			g.IsBackground = readBool(r)
-			g.WaitSince = readUint64(r)
-			g.WaitReason = readString(r)
-			g.ctxtaddr = readUint64(r)
-			g.maddr = readUint64(r)
-			g.deferaddr = readUint64(r)
-			g.panicaddr = readUint64(r)
-			d.Goroutines = append(d.Goroutines, g)
-		case tagStackFrame:
-			t := &StackFrame{}
-			t.Addr = readUint64(r)
-			t.Depth = readUint64(r)
-			t.childaddr = readUint64(r)
-			t.Data = readBytes(r)
-			t.entry = readUint64(r)
-			t.pc = readUint64(r)
-			readUint64(r) // continpc
-			t.Name = readString(r)
-			t.Fields = readFields(r)
-			d.Frames = append(d.Frames, t)
-		case tagParams:
-			if readUint64(r) == 0 {
-				d.Order = binary.LittleEndian
-			} else {
-				d.Order = binary.BigEndian
+	params := false
+	// Read records until the EOF record.  If the dump ends before
+	// that, keep what we have read so far.
+	func() {
+		defer func() {
+			if e := recover(); e != nil {
+				if _, ok := e.(truncatedError); !ok {
+					panic(e)
+				}
+				d.Truncated = true
 			}
-			d.PtrSize = readUint64(r)
-			d.HChanSize = readUint64(r)
-			d.HeapStart = readUint64(r)
-			d.HeapEnd = readUint64(r)
-			d.TheChar = byte(readUint64(r))
-			d.Experiment = readString(r)
-			d.Ncpu = readUint64(r)
-		case tagFinalizer:
-			t := &Finalizer{}
-			t.obj = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
-			t.fint = readUint64(r)
-			t.ot = readUint64(r)
-			d.Finalizers = append(d.Finalizers, t)
-		case tagQFinal:
-			t := &QFinalizer{}
-			t.obj = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
-			t.fint = readUint64(r)
-			t.ot = readUint64(r)
-			d.QFinal = append(d.QFinal, t)
-		case tagData:
-			t := &Data{}
-			t.Addr = readUint64(r)
-			t.Data = readBytes(r)
-			t.Fields = readFields(r)
-			d.Data = t
-		case tagBss:
-			t := &Data{}
-			t.Addr = readUint64(r)
-			t.Data = readBytes(r)
-			t.Fields = readFields(r)
-			d.Bss = t
-		case tagItab:
-			addr := readUint64(r)
-			ptr := readBool(r)
-			d.ItabMap[addr] = ptr
-		case tagOSThread:
-			t := &OSThread{}
-			t.addr = readUint64(r)
-			t.id = readUint64(r)
-			t.procid = readUint64(r)
-			d.Osthreads = append(d.Osthreads, t)
-		case tagMemStats:
-			t := &runtime.MemStats{}
-			t.Alloc = readUint64(r)
-			t.TotalAlloc = readUint64(r)
-			t.Sys = readUint64(r)
-			t.Lookups = readUint64(r)
-			t.Mallocs = readUint64(r)
-			t.Frees = readUint64(r)
-			t.HeapAlloc = readUint64(r)
-			t.HeapSys = readUint64(r)
-			t.HeapIdle = readUint64(r)
-			t.HeapInuse = readUint64(r)
-			t.HeapReleased = readUint64(r)
-			t.HeapObjects = readUint64(r)
-			t.StackInuse = readUint64(r)
-			t.StackSys = readUint64(r)
-			t.MSpanInuse = readUint64(r)
-			t.MSpanSys = readUint64(r)
-			t.MCacheInuse = readUint64(r)
-			t.MCacheSys = readUint64(r)
-			t.BuckHashSys = readUint64(r)
-			t.GCSys = readUint64(r)
-			t.OtherSys = readUint64(r)
-			t.NextGC = readUint64(r)
-			t.LastGC = readUint64(r)
-			t.PauseTotalNs = readUint64(r)
-			for i := 0; i < 256; i++ {
-				t.PauseNs[i] = readUint64(r)
+		}()
+		for {
+			off := r.Count()
+			if opts.Progress != nil && off >= next {
+				opts.Progress(off, total)
+				next = off + step
 			}
-			t.NumGC = uint32(readUint64(r))
-			d.Memstats = t
-		case tagDefer:
-			t := &Defer{}
-			t.addr = readUint64(r)
-			t.gp = readUint64(r)
-			t.argp = readUint64(r)
-			t.pc = readUint64(r)
-			t.fn = readUint64(r)
-			t.code = readUint64(r)
-			t.link = readUint64(r)
-			d.Defers = append(d.Defers, t)
-		case tagPanic:
-			t := &Panic{}
-			t.addr = readUint64(r)
-			t.gp = readUint64(r)
-			t.typ = readUint64(r)
-			t.data = readUint64(r)
-			t.defr = readUint64(r)
-			t.link = readUint64(r)
-			d.Panics = append(d.Panics, t)
-		case tagMemProf:
-			t := &MemProfEntry{}
-			key := readUint64(r)
-			t.size = readUint64(r)
-			nstk := readUint64(r)
-			for i := uint64(0); i < nstk; i++ {
-				fn := readString(r)
-				file := readString(r)
-				line := readUint64(r)
-				// TODO: intern fn, file.  They will repeat a lot.
-				t.stack = append(t.stack, MemProfFrame{fn, file, line})
+			kind := readUint64(r)
+			switch kind {
+			case tagObject:
+				obj := object{}
+				obj.Addr = readUint64(r)
+				typaddr := readUint64(r)
+				kind := TypeKind(readUint64(r))
+				size := readUint64(r)
+				k := tkey{typaddr, kind, size}
+				ft := ftmap[k]
+				if ft == nil {
+					ft = d.makeFullType(typaddr, kind, size)
+					ftmap[k] = ft
+				}
+				obj.Ft = ft
+				if opts.histogramOnly {
+					if err := r.Skip(int64(ft.Size)); err != nil {
+						readError(err)
+					}
+					for len(d.histo) <= ft.Id {
+						d.histo = append(d.histo, TypeStat{})
+					}
+					d.histo[ft.Id].Count++
+					d.histo[ft.Id].Bytes += ft.Size
+					continue
+				}
+				if ra != nil {
+					obj.offset = r.Count()
+					if err := r.Skip(int64(ft.Size)); err != nil {
+						readError(err)
+					}
+				} else {
+					obj.offset = int64(len(mem))
+					mem = append(mem, make([]byte, ft.Size)...)
+					if _, err := io.ReadFull(r, mem[obj.offset:]); err != nil {
+						readError(err)
+					}
+				}
+				d.objects = append(d.objects, obj)
+			case tagEOF:
+				return
+			case tagOtherRoot:
+				t := &OtherRoot{}
+				t.Description = readString(r)
+				t.toaddr = readUint64(r)
+				d.Otherroots = append(d.Otherroots, t)
+			case tagType:
+				typ := &Type{}
+				typ.Addr = readUint64(r)
+				typ.Size = readUint64(r)
+				typ.Name = readString(r)
+				typ.efaceptr = readBool(r)
+				typ.Fields = readFields(r)
+				typ.dumpFields = append([]Field(nil), typ.Fields...)
+				// Note: there may be duplicate type records in a dump.
+				// The duplicates get thrown away here.
+				if _, ok := d.TypeMap[typ.Addr]; !ok {
+					d.TypeMap[typ.Addr] = typ
+					d.Types = append(d.Types, typ)
+				}
+			case tagGoRoutine:
+				g := &GoRoutine{}
+				g.Addr = readUint64(r)
+				g.bosaddr = readUint64(r)
+				g.Goid = readUint64(r)
+				g.Gopc = readUint64(r)
+				g.Status = readUint64(r)
+				g.IsSystem = readBool(r)
+				g.IsBackground = readBool(r)
+				g.WaitSince = readUint64(r)
+				g.WaitReason = readString(r)
+				g.ctxtaddr = readUint64(r)
+				g.maddr = readUint64(r)
+				g.deferaddr = readUint64(r)
+				g.panicaddr = readUint64(r)
+				d.Goroutines = append(d.Goroutines, g)
+			case tagStackFrame:
+				t := &StackFrame{}
+				t.Addr = readUint64(r)
+				t.Depth = readUint64(r)
+				t.childaddr = readUint64(r)
+				t.Data = readBytes(r)
+				t.entry = readUint64(r)
+				t.pc = readUint64(r)
+				readUint64(r) // continpc
+				t.Name = readString(r)
+				t.Fields = readFields(r)
+				d.Frames = append(d.Frames, t)
+			case tagParams:
+				params = true
+				if readUint64(r) == 0 {
+					d.Order = binary.LittleEndian
+				} else {
+					d.Order = binary.BigEndian
+				}
+				d.PtrSize = readUint64(r)
+				d.HChanSize = readUint64(r)
+				d.HeapStart = readUint64(r)
+				d.HeapEnd = readUint64(r)
+				d.TheChar = byte(readUint64(r))
+				d.Experiment = readString(r)
+				d.Ncpu = readUint64(r)
+			case tagFinalizer:
+				t := &Finalizer{}
+				t.obj = readUint64(r)
+				t.fn = readUint64(r)
+				t.code = readUint64(r)
+				t.fint = readUint64(r)
+				t.ot = readUint64(r)
+				d.Finalizers = append(d.Finalizers, t)
+			case tagQFinal:
+				t := &QFinalizer{}
+				t.obj = readUint64(r)
+				t.fn = readUint64(r)
+				t.code = readUint64(r)
+				t.fint = readUint64(r)
+				t.ot = readUint64(r)
+				d.QFinal = append(d.QFinal, t)
+			case tagData:
+				t := &Data{}
+				t.Addr = readUint64(r)
+				t.Data = readBytes(r)
+				t.Fields = readFields(r)
+				t.dumpFields = append([]Field(nil), t.Fields...)
+				d.Data = t
+			case tagBss:
+				t := &Data{}
+				t.Addr = readUint64(r)
+				t.Data = readBytes(r)
+				t.Fields = readFields(r)
+				t.dumpFields = append([]Field(nil), t.Fields...)
+				d.Bss = t
+			case tagItab:
+				addr := readUint64(r)
+				ptr := readBool(r)
+				d.ItabMap[addr] = ptr
+			case tagOSThread:
+				t := &OSThread{}
+				t.addr = readUint64(r)
+				t.id = readUint64(r)
+				t.procid = readUint64(r)
+				d.Osthreads = append(d.Osthreads, t)
+			case tagMemStats:
+				t := &runtime.MemStats{}
+				t.Alloc = readUint64(r)
+				t.TotalAlloc = readUint64(r)
+				t.Sys = readUint64(r)
+				t.Lookups = readUint64(r)
+				t.Mallocs = readUint64(r)
+				t.Frees = readUint64(r)
+				t.HeapAlloc = readUint64(r)
+				t.HeapSys = readUint64(r)
+				t.HeapIdle = readUint64(r)
+				t.HeapInuse = readUint64(r)
+				t.HeapReleased = readUint64(r)
+				t.HeapObjects = readUint64(r)
+				t.StackInuse = readUint64(r)
+				t.StackSys = readUint64(r)
+				t.MSpanInuse = readUint64(r)
+				t.MSpanSys = readUint64(r)
+				t.MCacheInuse = readUint64(r)
+				t.MCacheSys = readUint64(r)
+				t.BuckHashSys = readUint64(r)
+				t.GCSys = readUint64(r)
+				t.OtherSys = readUint64(r)
+				t.NextGC = readUint64(r)
+				t.LastGC = readUint64(r)
+				t.PauseTotalNs = readUint64(r)
+				for i := 0; i < 256; i++ {
+					t.PauseNs[i] = readUint64(r)
+				}
+				t.NumGC = uint32(readUint64(r))
+				d.Memstats = t
+			case tagDefer:
+				t := &Defer{}
+				t.addr = readUint64(r)
+				t.gp = readUint64(r)
+				t.argp = readUint64(r)
+				t.pc = readUint64(r)
+				t.fn = readUint64(r)
+				t.code = readUint64(r)
+				t.link = readUint64(r)
+				d.Defers = append(d.Defers, t)
+			case tagPanic:
+				t := &Panic{}
+				t.addr = readUint64(r)
+				t.gp = readUint64(r)
+				t.typ = readUint64(r)
+				t.data = readUint64(r)
+				t.defr = readUint64(r)
+				t.link = readUint64(r)
+				d.Panics = append(d.Panics, t)
+			case tagMemProf:
+				t := &MemProfEntry{}
+				key := readUint64(r)
+				t.size = readUint64(r)
+				nstk := readUint64(r)
+				for i := uint64(0); i < nstk; i++ {
+					fn := readString(r)
+					file := readString(r)
+					line := readUint64(r)
+					// TODO: intern fn, file.  They will repeat a lot.
+					t.stack = append(t.stack, MemProfFrame{fn, file, line})
+				}
+				t.allocs = readUint64(r)
+				t.frees = readUint64(r)
+				d.MemProf = append(d.MemProf, t)
+				memprof[key] = t
+			case tagAllocSample:
+				t := &AllocSample{}
+				t.Addr = readUint64(r)
+				t.Prof = memprof[readUint64(r)]
+				d.AllocSamples = append(d.AllocSamples, t)
+			default:
+				// Records carry no length, so there is no way to skip
+				// a record we don't understand.  All the record kinds
+				// the runtime writes are handled above; anything else
+				// means a corrupt dump or a newer format.
+				Logger.Fatalf("unknown record kind %d at offset %d", kind, off)
 			}
-			t.allocs = readUint64(r)
-			t.frees = readUint64(r)
-			d.MemProf = append(d.MemProf, t)
-			memprof[key] = t
-		case tagAllocSample:
-			t := &AllocSample{}
-			t.Addr = readUint64(r)
-			t.Prof = memprof[readUint64(r)]
-			d.AllocSamples = append(d.AllocSamples, t)
-		default:
-			log.Fatal("unknown record kind ", kind)
 		}
-	}
+	}()
 	// TODO: any easy way to truncate the objects array?  We could
 	// reclaim the fraction that append() added but we didn't need.
+	if d.Truncated {
+		Logger.Printf("heap dump is truncated, using the %d objects read before the end", len(d.objects))
+	}
+
+	// The params record can appear anywhere in the dump, so
+	// anything that depends on it is done after reading.
+	if !params {
+		Logger.Fatal("heap dump has no params record")
+	}
+	if d.PtrSize != 4 && d.PtrSize != 8 {
+		Logger.Fatalf("unsupported pointer size %d in params record", d.PtrSize)
+	}
+	if a, ok := archs[d.TheChar]; ok && a.ptrSize != d.PtrSize {
+		Logger.Printf("pointer size %d doesn't match architecture %s", d.PtrSize, a.name)
+	}
+	if d.HChanSize == 0 {
+		Logger.Print("heap dump has channel header size 0, scanning channel elements from offset 0")
+	} else if min := minHChanSize(d.PtrSize); d.HChanSize < min {
+		Logger.Printf("channel header size %d is smaller than the minimum %d, channel edges may be wrong", d.HChanSize, min)
+	}
+	if d.Data == nil {
+		d.Data = &Data{}
+	}
+	if d.Bss == nil {
+		d.Bss = &Data{}
+	}
+	if opts.Progress != nil {
+		opts.Progress(r.Count(), total)
+	}
+	if ra == nil {
+		d.r = bytes.NewReader(mem)
+	}
+	return &d
 }
 
 func getDwarf(execname string) *dwarf.Data {
+	d, err := loadDwarf(execname)
+	if err != nil {
+		Logger.Fatal(err)
+	}
+	return d
+}
+
+// loadDwarf returns the Dwarf info of the executable execname, which
+// can be an ELF, Mach-O, or PE file.
+func loadDwarf(execname string) (*dwarf.Data, error) {
 	e, err := elf.Open(execname)
 	if err == nil {
 		defer e.Close()
-		d, err := e.DWARF()
-		if err == nil {
-			return d
-		}
+		return e.DWARF()
 	}
 	m, err := macho.Open(execname)
 	if err == nil {
 		defer m.Close()
-		d, err := m.DWARF()
-		if err == nil {
-			return d
-		}
+		return m.DWARF()
 	}
 	p, err := pe.Open(execname)
 	if err == nil {
 		defer p.Close()
-		d, err := p.DWARF()
-		if err == nil {
-			return d
-		}
+		return p.DWARF()
 	}
-	log.Fatal("can't get dwarf info from executable", err)
-	return nil
+	return nil, fmt.Errorf("can't get dwarf info from executable %s: %v", execname, err)
 }
 
 func readUleb(b []byte) ([]byte, uint64) {
@@ -840,7 +1432,8 @@ type dwarfPtrType struct {
 }
 type dwarfArrayType struct {
 	dwarfTypeImpl
-	elem dwarfType
+	elem  dwarfType
+	count uint64 // number of elements, if there was no byte size
 }
 type dwarfFuncType struct {
 	dwarfTypeImpl
@@ -884,7 +1477,7 @@ func (t *dwarfBaseType) Fields() []Field {
 	case t.encoding == dw_ate_complex_float && t.size == 16:
 		t.fields = append(t.fields, Field{FieldKindComplex128, 0, "", ""})
 	default:
-		log.Fatalf("unknown encoding type encoding=%d size=%d", t.encoding, t.size)
+		Logger.Fatalf("unknown encoding type encoding=%d size=%d", t.encoding, t.size)
 	}
 	return t.fields
 }
@@ -954,10 +1547,19 @@ func (t *dwarfStructType) Fields() []Field {
 	}
 	return t.fields
 }
+func (t *dwarfArrayType) Size() uint64 {
+	if t.size == 0 && t.count != 0 && t.elem != nil {
+		t.size = t.count * t.elem.Size()
+	}
+	return t.size
+}
 func (t *dwarfArrayType) Fields() []Field {
-	if t.fields != nil {
+	if t.fields != nil || t.elem == nil {
 		return t.fields
 	}
+	// Note: elements which are themselves arrays (multidimensional
+	// arrays) are flattened here too, so every pointer in an array
+	// gets its own field, not just the first one.
 	s := t.elem.Size()
 	if s == 0 {
 		return t.fields
@@ -995,7 +1597,7 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			Logger.Fatal(err)
 		}
 		if e == nil {
 			break
@@ -1033,7 +1635,9 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 		case dwarf.TagArrayType:
 			x := new(dwarfArrayType)
 			x.name = e.Val(dwarf.AttrName).(string)
-			x.size = uint64(e.Val(dwarf.AttrByteSize).(int64))
+			if n, ok := e.Val(dwarf.AttrByteSize).(int64); ok {
+				x.size = uint64(n)
+			}
 			t[e.Offset] = x
 		case dwarf.TagTypedef:
 			x := new(dwarfTypedef)
@@ -1050,10 +1654,11 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 	// pass 2: fill in / link up the types
 	r = w.Reader()
 	var currentStruct *dwarfStructType
+	var currentArray *dwarfArrayType
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			Logger.Fatal(err)
 		}
 		if e == nil {
 			break
@@ -1062,7 +1667,7 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 		case dwarf.TagTypedef:
 			t[e.Offset].(*dwarfTypedef).type_ = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
 			if t[e.Offset].(*dwarfTypedef).type_ == nil {
-				log.Fatalf("can't find referent for %s %d\n", t[e.Offset].(*dwarfTypedef).name, e.Val(dwarf.AttrType).(dwarf.Offset))
+				Logger.Fatalf("can't find referent for %s %d\n", t[e.Offset].(*dwarfTypedef).name, e.Val(dwarf.AttrType).(dwarf.Offset))
 			}
 		case dwarf.TagPointerType:
 			i := e.Val(dwarf.AttrType)
@@ -1071,21 +1676,23 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 			}
 			// The only nil cases are unsafe.Pointer and reflect.iword
 		case dwarf.TagArrayType:
-			t[e.Offset].(*dwarfArrayType).elem = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+			currentArray, _ = t[e.Offset].(*dwarfArrayType)
+			if currentArray != nil {
+				currentArray.elem = t[e.Val(dwarf.AttrType).(dwarf.Offset)]
+			}
+		case dwarf.TagSubrangeType:
+			// Arrays without a byte size get it from the element count.
+			if n, ok := e.Val(dwarf.AttrCount).(int64); ok && currentArray != nil && currentArray.size == 0 {
+				currentArray.count = uint64(n)
+			}
 		case dwarf.TagStructType:
 			currentStruct = t[e.Offset].(*dwarfStructType)
 		case dwarf.TagMember:
 			name := e.Val(dwarf.AttrName).(string)
 			type_ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrDataMemberLoc).([]uint8)
-			var offset uint64
-			if len(loc) == 0 {
-				offset = 0
-			} else if len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus {
-				loc, offset = readUleb(loc[1 : len(loc)-1])
-				if len(loc) != 0 {
-					break
-				}
+			offset, ok := memberOffset(e)
+			if !ok {
+				break
 			}
 			currentStruct.members = append(currentStruct.members, dwarfTypeMember{name, offset, type_})
 		}
@@ -1093,31 +1700,59 @@ func typeMap(d *Dump, w *dwarf.Data) map[dwarf.Offset]dwarfType {
 	return t
 }
 
+// memberOffset returns the offset of struct member e.  The offset is
+// either a constant or a location expression (DW_OP_plus_uconst n, or
+// DW_OP_consts n DW_OP_plus); a member with no location, or an empty
+// one, is at offset 0.  It returns false if the location expression
+// isn't understood.
+func memberOffset(e *dwarf.Entry) (uint64, bool) {
+	switch loc := e.Val(dwarf.AttrDataMemberLoc).(type) {
+	case int64:
+		return uint64(loc), true
+	case []uint8:
+		switch {
+		case len(loc) == 0:
+			return 0, true
+		case len(loc) >= 2 && loc[0] == dw_op_plus_uconst:
+			loc, offset := readUleb(loc[1:])
+			return offset, len(loc) == 0
+		case len(loc) >= 2 && loc[0] == dw_op_consts && loc[len(loc)-1] == dw_op_plus:
+			loc, offset := readUleb(loc[1 : len(loc)-1])
+			return offset, len(loc) == 0
+		}
+		return 0, false
+	}
+	return 0, true
+}
+
+// Locals and args are keyed by the entry pc of their function, not its
+// name, as names need not be unique across compilation units (e.g.
+// static C functions).
 type localKey struct {
-	funcname string
-	offset   uint64 // distance down from frame pointer
+	entry  uint64
+	offset uint64 // distance down from frame pointer
 }
 
-// Makes a map from <function name, distance before top of frame> to name of field.
+// Makes a map from <function entry pc, distance before top of frame> to name of field.
 func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]string {
 	m := make(map[localKey]string, 0)
 	r := w.Reader()
-	var funcname string
+	var entry uint64
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			Logger.Fatal(err)
 		}
 		if e == nil {
 			break
 		}
 		switch e.Tag {
 		case dwarf.TagSubprogram:
-			funcname = e.Val(dwarf.AttrName).(string)
+			entry, _ = e.Val(dwarf.AttrLowpc).(uint64)
 		case dwarf.TagVariable:
 			name := e.Val(dwarf.AttrName).(string)
 			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
+			loc, _ := e.Val(dwarf.AttrLocation).([]uint8)
 			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
 				break
 			}
@@ -1131,36 +1766,36 @@ func localsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKe
 				}
 			}
 			for _, f := range typ.Fields() {
-				m[localKey{funcname, uint64(-offset) - f.Offset}] = joinNames(name, f.Name)
+				m[localKey{entry, uint64(-offset) - f.Offset}] = joinNames(name, f.Name)
 			}
 		}
 	}
 	return m
 }
 
-// Makes a map from <function name, offset in arg area> to name of field.
+// Makes a map from <function entry pc, offset in arg area> to name of field.
 func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]string {
 	m := make(map[localKey]string, 0)
 	r := w.Reader()
-	var funcname string
+	var entry uint64
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			Logger.Fatal(err)
 		}
 		if e == nil {
 			break
 		}
 		switch e.Tag {
 		case dwarf.TagSubprogram:
-			funcname = e.Val(dwarf.AttrName).(string)
+			entry, _ = e.Val(dwarf.AttrLowpc).(uint64)
 		case dwarf.TagFormalParameter:
 			if e.Val(dwarf.AttrName) == nil {
 				continue
 			}
 			name := e.Val(dwarf.AttrName).(string)
 			typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-			loc := e.Val(dwarf.AttrLocation).([]uint8)
+			loc, _ := e.Val(dwarf.AttrLocation).([]uint8)
 			if len(loc) == 0 || loc[0] != dw_op_call_frame_cfa {
 				break
 			}
@@ -1174,7 +1809,7 @@ func argsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) map[localKey]
 				}
 			}
 			for _, f := range typ.Fields() {
-				m[localKey{funcname, uint64(offset)}] = joinNames(name, f.Name)
+				m[localKey{entry, uint64(offset)}] = joinNames(name, f.Name)
 			}
 		}
 	}
@@ -1188,7 +1823,7 @@ func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
 	for {
 		e, err := r.Next()
 		if err != nil {
-			log.Fatal(err)
+			Logger.Fatal(err)
 		}
 		if e == nil {
 			break
@@ -1198,7 +1833,7 @@ func globalsMap(d *Dump, w *dwarf.Data, t map[dwarf.Offset]dwarfType) *heap {
 		}
 		name := e.Val(dwarf.AttrName).(string)
 		typ := t[e.Val(dwarf.AttrType).(dwarf.Offset)]
-		locexpr := e.Val(dwarf.AttrLocation).([]uint8)
+		locexpr, _ := e.Val(dwarf.AttrLocation).([]uint8)
 		if len(locexpr) == 0 || locexpr[0] != dw_op_addr {
 			continue
 		}
@@ -1249,6 +1884,7 @@ func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
 		case FieldKindSlice:
 			edges = d.appendEdge(edges, data, off, f)
 		case FieldKindEface:
+			// See the comment in Edges.
 			edges = d.appendEdge(edges, data, off, f)
 			tp := readPtr(d, data[off:])
 			if tp != 0 {
@@ -1257,14 +1893,15 @@ func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
 					//log.Fatal("can't find eface type")
 					continue
 				}
-				if t.efaceptr {
+				if t.EfaceDataIsPointer() {
 					edges = d.appendEdge(edges, data, off+d.PtrSize, f)
 				}
 			}
 		case FieldKindIface:
+			edges = d.appendEdge(edges, data, off, f)
 			tp := readPtr(d, data[off:])
 			if tp != 0 {
-				if d.ItabMap[tp] {
+				if ptr, _ := d.IfaceDataIsPointer(tp); ptr {
 					edges = d.appendEdge(edges, data, off+d.PtrSize, f)
 				}
 			}
@@ -1274,8 +1911,7 @@ func (d *Dump) appendFields(edges []Edge, data []byte, fields []Field) []Edge {
 }
 
 // Names the fields it can for better debugging output
-func nameWithDwarf(d *Dump, execname string) {
-	w := getDwarf(execname)
+func nameWithDwarf(d *Dump, w *dwarf.Data) {
 	t := typeMap(d, w)
 
 	// name fields in all types
@@ -1296,37 +1932,21 @@ func nameWithDwarf(d *Dump, execname string) {
 		// missing non-pointer-bearing fields and has no field names.  If the
 		// Dwarf type is consistent with the heap dump type, then we'll use
 		// the fields from the Dwarf type instead.
-		consistent := true
-
-		// load Dwarf fields into layout
-		df := dt.Fields()
-		layout := make(map[uint64]Field)
-		for _, f := range df {
-			layout[f.Offset] = f
-		}
-		// A field in the heap dump must match the corresponding Dwarf field
-		// in both kind and offset.
-		for _, f := range t.Fields {
-			if layout[f.Offset].Kind != f.Kind {
-				log.Printf("dwarf field kind doesn't match dump kind %s.%d dwarf=%d dump=%d", t.Name, f.Offset, layout[f.Offset].Kind, f.Kind)
-				consistent = false
-			}
-			delete(layout, f.Offset)
-		}
-		// all remaining fields must not be pointer-containing
-		for _, f := range layout {
-			switch f.Kind {
-			case FieldKindPtr, FieldKindString, FieldKindSlice, FieldKindIface, FieldKindEface:
-				log.Printf("dwarf type has additional ptr field %s %d %d", f.Name, f.Offset, f.Kind)
-				consistent = false
-			}
+		// A size mismatch alone is only a warning; the fields can
+		// still be named if the pointer layout matches.
+		if x, ok := dwarfSizeMismatch(t, dt); ok {
+			Logger.Print(x.Msg)
+		}
+		mismatches := dwarfMismatches(t, dt)
+		for _, x := range mismatches {
+			Logger.Print(x.Msg)
 		}
-		if consistent {
+		if len(mismatches) == 0 {
 			// Dwarf info looks good, overwrite the fields from the dump
 			// with fields from the Dwarf info.
-			t.Fields = df
+			t.Fields = dt.Fields()
 		} else {
-			log.Print("inconsistent type for", t.Name)
+			Logger.Print("inconsistent type for", t.Name)
 		}
 	}
 
@@ -1341,7 +1961,9 @@ func nameWithDwarf(d *Dump, execname string) {
 			continue
 		}
 		g := frames[frameKey{f.childaddr, f.Depth - 1}]
-		g.Parent = f
+		if g != nil {
+			g.Parent = f
+		}
 	}
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
@@ -1354,9 +1976,9 @@ func nameWithDwarf(d *Dump, execname string) {
 		var c *StackFrame
 		for r := g.Bos; r != nil; r = r.Parent {
 			for i, f := range r.Fields {
-				name := locals[localKey{r.Name, uint64(len(r.Data)) - f.Offset}]
+				name := locals[localKey{r.entry, uint64(len(r.Data)) - f.Offset}]
 				if name == "" && c != nil {
-					name = args[localKey{c.Name, f.Offset}]
+					name = args[localKey{c.entry, f.Offset}]
 					if name != "" {
 						name = "outarg." + name
 					}
@@ -1389,11 +2011,9 @@ func nameWithDwarf(d *Dump, execname string) {
 	}
 }
 
-func link(d *Dump) {
-	// sort objects in increasing address order
-	sort.Sort(byAddr(d.objects))
-
-	// initialize index array
+// buildIndex initializes the index used by FindObj.  The objects
+// must already be sorted by address.
+func (d *Dump) buildIndex() {
 	d.idx = make([]ObjId, (d.HeapEnd-d.HeapStart+bucketSize-1)/bucketSize)
 	for i := len(d.idx) - 1; i >= 0; i-- {
 		d.idx[i] = ObjId(len(d.objects))
@@ -1407,6 +2027,19 @@ func link(d *Dump) {
 			d.idx[j] = ObjId(i)
 		}
 	}
+}
+
+func link(d *Dump) {
+	// The object index and the stacks don't depend on each other, so
+	// they are put together concurrently.  Edges need both.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// sort objects in increasing address order
+		sort.Sort(byAddr(d.objects))
+		d.buildIndex()
+	}()
 
 	// initialize some maps used for linking
 	frames := make(map[frameKey]*StackFrame, len(d.Frames))
@@ -1414,32 +2047,82 @@ func link(d *Dump) {
 		frames[frameKey{x.Addr, x.Depth}] = x
 	}
 
-	// link stack frames to objects
-	for _, f := range d.Frames {
-		f.Edges = d.appendFields(f.Edges, f.Data, f.Fields)
-	}
-
 	// link up frames in sequence
 	for _, f := range d.Frames {
 		if f.Depth == 0 {
 			continue
 		}
 		g := frames[frameKey{f.childaddr, f.Depth - 1}]
+		if g == nil {
+			// The stack is broken above f; see Validate.
+			Logger.Printf("can't find child of frame %s at depth %d", f.Name, f.Depth)
+			continue
+		}
 		g.Parent = f
 	}
 
 	// link goroutines to frames & vice versa
+	if d.Truncated {
+		// drop goroutines whose stacks are missing
+		var gs []*GoRoutine
+		for _, g := range d.Goroutines {
+			if frames[frameKey{g.bosaddr, 0}] != nil {
+				gs = append(gs, g)
+			}
+		}
+		d.Goroutines = gs
+	}
 	for _, g := range d.Goroutines {
 		g.Bos = frames[frameKey{g.bosaddr, 0}]
 		if g.Bos == nil {
-			log.Fatal("bos missing")
+			Logger.Fatal("bos missing")
 		}
 		for f := g.Bos; f != nil; f = f.Parent {
 			f.Goroutine = g
 		}
-		x := d.FindObj(g.ctxtaddr)
+	}
+	wg.Wait()
+
+	// link stack frames to objects
+	for _, f := range d.Frames {
+		f.Edges = d.appendFields(f.Edges, f.Data, f.Fields)
+	}
+
+	for _, g := range d.Goroutines {
+		if g.WaitSince > d.latestWait {
+			d.latestWait = g.WaitSince
+		}
+		g.Ctxt = d.FindObj(g.ctxtaddr)
+		if g.Ctxt != ObjNil {
+			g.Edges = append(g.Edges, Edge{g.Ctxt, 0, g.ctxtaddr - d.objects[g.Ctxt].Addr, "ctxt"})
+		}
+	}
+
+	// link goroutines to the objects their defers and panics use.
+	// Defer and panic records may themselves live in the heap.
+	gmap := make(map[uint64]*GoRoutine, len(d.Goroutines))
+	for _, g := range d.Goroutines {
+		gmap[g.Addr] = g
+	}
+	goEdge := func(gp uint64, addr uint64, name string) {
+		g := gmap[gp]
+		if g == nil {
+			return
+		}
+		x := d.FindObj(addr)
 		if x != ObjNil {
-			g.Ctxt = x
+			g.Edges = append(g.Edges, Edge{x, 0, addr - d.objects[x].Addr, name})
+		}
+	}
+	for _, x := range d.Defers {
+		goEdge(x.gp, x.addr, "defer")
+		goEdge(x.gp, x.fn, "defer.fn")
+	}
+	for _, x := range d.Panics {
+		goEdge(x.gp, x.addr, "panic")
+		// The panic value is an eface; see appendFields.
+		if t := d.TypeMap[x.typ]; t != nil && t.EfaceDataIsPointer() {
+			goEdge(x.gp, x.data, "panic.data")
 		}
 	}
 
@@ -1479,6 +2162,35 @@ func link(d *Dump) {
 	}
 }
 
+// ApplyDwarf names the fields of types, and the locals and globals,
+// of d using the Dwarf info w, as if d had been read by ReadWithDwarf.
+// It lets names be added to a dump read without Dwarf info (or with
+// the wrong executable's) without reparsing the dump.  Types renamed
+// by Options.TypeNameFilter may not be found in w.  If the object
+// contents were dropped (see Options.DropDataAfterLink), edges between
+// objects keep their old field names.
+func (d *Dump) ApplyDwarf(w *dwarf.Data) {
+	for _, t := range d.Types {
+		t.Fields = append([]Field(nil), t.dumpFields...)
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		x.Fields = append([]Field(nil), x.dumpFields...)
+	}
+	nameWithDwarf(d, w)
+	for _, ft := range d.FTList {
+		ft.Fields = nil
+	}
+	nameFullTypes(d)
+
+	// Edges from roots carry the names of the fields they come from.
+	for _, f := range d.Frames {
+		f.Edges = d.appendFields(nil, f.Data, f.Fields)
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		x.Edges = d.appendFields(nil, x.Data, x.Fields)
+	}
+}
+
 func nameFallback(d *Dump) {
 	// No dwarf info, just name generically
 	for _, t := range d.Types {
@@ -1518,8 +2230,39 @@ var chanFields = map[uint64]map[uint64]string{
 	},
 }
 
+// Pointer fields in the channel header: the lists of goroutines
+// (SudoGs) waiting to receive and send.  These need to be scanned
+// so that waiters that live in the heap are reachable.
+var chanPtrFields = map[uint64]map[uint64]string{
+	4: map[uint64]string{
+		28: "recvq.first",
+		32: "recvq.last",
+		36: "sendq.first",
+		40: "sendq.last",
+	},
+	8: map[uint64]string{
+		48: "recvq.first",
+		56: "recvq.last",
+		64: "sendq.first",
+		72: "sendq.last",
+	},
+}
+
+// minHChanSize returns the smallest plausible channel header size:
+// the header must hold at least the fields in chanPtrFields.
+func minHChanSize(ptrSize uint64) uint64 {
+	var m uint64
+	for off := range chanPtrFields[ptrSize] {
+		if off+ptrSize > m {
+			m = off + ptrSize
+		}
+	}
+	return m
+}
+
 func nameFullTypes(d *Dump) {
 	for _, ft := range d.FTList {
+		d.nameFullType(ft)
 		t := ft.Typ
 		switch {
 		case ft.Typ == nil && ft.Kind == TypeKindConservative:
@@ -1546,14 +2289,19 @@ func nameFullTypes(d *Dump) {
 				case 8:
 					ft.Fields = append(ft.Fields, Field{FieldKindBytes8, i, fmt.Sprintf("offset %x", i), ""})
 				default:
-					log.Fatalf("weird size obj", ft.Size)
+					// odd-sized tail, just show it as elided
+					ft.Fields = append(ft.Fields, Field{FieldKindBytesElided, i, fmt.Sprintf("offset %x", i), ""})
 				}
 			}
 		case ft.Typ != nil && ft.Kind == TypeKindObject:
 			ft.Fields = ft.Typ.Fields
 		case ft.Typ != nil && ft.Kind == TypeKindArray:
+			// Only whole elements are scanned.  The object size is
+			// the size of the allocation, which can be rounded up
+			// past the last element (to a size class, or to whole
+			// pages for large objects).
 			t := ft.Typ
-			for i := uint64(0); i <= ft.Size-t.Size; i += t.Size {
+			for i := uint64(0); t.Size > 0 && i+t.Size <= ft.Size; i += t.Size {
 				for _, f := range t.Fields {
 					var name string
 					if f.Name != "" {
@@ -1567,21 +2315,24 @@ func nameFullTypes(d *Dump) {
 		case ft.Typ != nil && ft.Kind == TypeKindChan:
 			fmap := chanFields[d.PtrSize]
 			if fmap == nil {
-				log.Fatal("can't find channel header info for ptr size")
+				Logger.Fatal("can't find channel header info for ptr size")
 			}
 			k := FieldKindUInt64
 			if d.PtrSize == 4 {
 				k = FieldKindUInt32
 			}
+			pmap := chanPtrFields[d.PtrSize]
 			for i := uint64(0); i < d.HChanSize; i += d.PtrSize {
-				if name, ok := fmap[i]; ok {
+				if name, ok := pmap[i]; ok {
+					ft.Fields = append(ft.Fields, Field{FieldKindPtr, i, name, ""})
+				} else if name, ok := fmap[i]; ok {
 					ft.Fields = append(ft.Fields, Field{k, i, name, ""})
 				} else {
 					ft.Fields = append(ft.Fields, Field{k, i, "chanhdr", ""})
 				}
 			}
 			if t.Size > 0 {
-				for i := d.HChanSize; i <= ft.Size-t.Size; i += t.Size {
+				for i := d.HChanSize; i+t.Size <= ft.Size; i += t.Size {
 					for _, f := range t.Fields {
 						var name string
 						if f.Name != "" {
@@ -1594,7 +2345,7 @@ func nameFullTypes(d *Dump) {
 				}
 			}
 		default:
-			log.Fatal("bad type/kind combo", ft.Typ, ft.Kind)
+			Logger.Fatal("bad type/kind combo", ft.Typ, ft.Kind)
 		}
 	}
 }
@@ -1606,17 +2357,150 @@ func (a byAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
 
 func Read(dumpname, execname string) *Dump {
-	d := rawRead(dumpname)
+	var w *dwarf.Data
 	if execname != "" {
-		nameWithDwarf(d, execname)
+		w = getDwarf(execname)
+	}
+	return ReadWithDwarf(dumpname, w)
+}
+
+// ReadWithDwarf is like Read, but names things using the already-loaded
+// Dwarf info w instead of the Dwarf info of an executable file.
+// If w is nil, generic names are used.
+func ReadWithDwarf(dumpname string, w *dwarf.Data) *Dump {
+	return ReadWithOptions(dumpname, w, nil)
+}
+
+// Options control how a dump is read.  A nil *Options means the defaults.
+type Options struct {
+	// TypeNameFilter, if not nil, is applied to the name of every
+	// type in the dump.  It can be used to normalize names, e.g. to
+	// collapse the instantiations of a generic type into one name
+	// for histograms.  It is applied after the Dwarf info has been
+	// matched up with the types, so it doesn't affect naming.
+	TypeNameFilter func(string) string
+
+	// Progress, if not nil, is called periodically while the dump
+	// file is read with the number of bytes read so far and the
+	// total size of the file (0 if unknown, see ReadStream).
+	Progress func(bytesRead, totalBytes int64)
+
+	// DropDataAfterLink, if set, makes the reader compute the edges
+	// of every object once and then discard the object contents.
+	// Graph analyses (histograms, reachability, dominators) still
+	// work, but Contents returns nil.  This mostly helps ReadStream,
+	// which otherwise keeps all the contents in memory; Read leaves
+	// the contents in the file until they are needed.
+	DropDataAfterLink bool
+
+	// ConservativeUntyped, if set, makes reachability, dominators,
+	// and retained sizes treat every aligned word of an object with
+	// no type that points into the heap as an edge.  Such objects
+	// are supposed to have no pointers, but if the type information
+	// is missing or wrong, this keeps whole subgraphs behind them
+	// from looking dead, at the price of some false edges.  Edges
+	// itself is not affected.  It can't be combined with
+	// DropDataAfterLink, as it needs the contents.
+	ConservativeUntyped bool
+
+	// histogramOnly makes the reader count the objects of each full
+	// type instead of keeping them, see ReadTypeHistogram.
+	histogramOnly bool
+}
+
+// ReadWithOptions is like ReadWithDwarf, but lets the caller control
+// how the dump is read.
+func ReadWithOptions(dumpname string, w *dwarf.Data, opts *Options) *Dump {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return process(rawRead(dumpname, opts), w, opts)
+}
+
+// ReadStream reads a heap dump from r, which is read sequentially and
+// need not support seeking.  It can be, for example, the body of an
+// http response.  Because the contents of objects can't be reread
+// from r later, they are all kept in memory, so this needs about as
+// much memory as the heap that was dumped.  The other arguments are
+// as for ReadWithOptions.
+func ReadStream(r io.Reader, w *dwarf.Data, opts *Options) *Dump {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return process(rawReadFrom(r, nil, 0, opts), w, opts)
+}
+
+// ReadTypeHistogram returns what TypeHistogram would return for the
+// dump in file dumpname, without keeping the objects or linking them
+// up.  It needs little more memory than the histogram itself, so it is
+// a cheap way to triage a dump too big to load.  Only opts.Progress
+// and opts.TypeNameFilter matter.
+func ReadTypeHistogram(dumpname string, opts *Options) []TypeStat {
+	o := Options{histogramOnly: true}
+	if opts != nil {
+		o.Progress = opts.Progress
+		o.TypeNameFilter = opts.TypeNameFilter
+	}
+	d := rawRead(dumpname, &o)
+	d.closeContents()
+	if o.TypeNameFilter != nil {
+		for _, t := range d.Types {
+			t.Name = o.TypeNameFilter(t.Name)
+		}
+	}
+	for _, ft := range d.FTList {
+		d.nameFullType(ft)
+	}
+	h := make([]TypeStat, len(d.FTList))
+	copy(h, d.histo)
+	return sortedTypeStats(d, h)
+}
+
+// process names and links the freshly read dump d.
+func process(d *Dump, w *dwarf.Data, opts *Options) *Dump {
+	if w != nil {
+		nameWithDwarf(d, w)
 	} else {
 		nameFallback(d)
 	}
+	if opts.TypeNameFilter != nil {
+		for _, t := range d.Types {
+			t.Name = opts.TypeNameFilter(t.Name)
+		}
+	}
 	nameFullTypes(d)
 	link(d)
+	d.conservative = opts.ConservativeUntyped
+	if opts.DropDataAfterLink && !opts.ConservativeUntyped {
+		d.dropContents()
+	}
 	return d
 }
 
+// dropContents computes the edges of all objects and then discards
+// the object contents.
+func (d *Dump) dropContents() {
+	n := d.NumObjects()
+	idx := make([]int, n+1)
+	var ref []Edge
+	for i := 0; i < n; i++ {
+		ref = append(ref, d.edges(ObjId(i))...)
+		idx[i+1] = len(ref)
+	}
+	d.edgeidx = idx
+	d.edgeref = ref
+	d.closeContents()
+}
+
+// closeContents forgets the source of object contents, closing it if
+// it is a file.
+func (d *Dump) closeContents() {
+	if c, ok := d.r.(io.Closer); ok {
+		c.Close()
+	}
+	d.r = nil
+}
+
 func readPtr(d *Dump, b []byte) uint64 {
 	switch d.PtrSize {
 	case 4:
@@ -1624,7 +2508,7 @@ func readPtr(d *Dump, b []byte) uint64 {
 	case 8:
 		return d.Order.Uint64(b)
 	default:
-		log.Fatal("unsupported PtrSize=%d", d.PtrSize)
+		Logger.Fatalf("unsupported PtrSize=%d", d.PtrSize)
 		return 0
 	}
 }
@@ -0,0 +1,173 @@
+package read
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// TestArrayTail checks that the fields of an array object stop at the
+// last whole element, even when the allocation was rounded up past it.
+func TestArrayTail(t *testing.T) {
+	d := NewDump(binary.LittleEndian, 8)
+	elem := d.AddType("main.elem", 40, []Field{
+		{Kind: FieldKindPtr, Offset: 0, Name: "a"},
+		{Kind: FieldKindPtr, Offset: 32, Name: "b"},
+	})
+	// A large allocation is a whole number of pages, here 9 pages
+	// holding 921 elements and a 24-byte tail.
+	const size = 9 * pageSize
+	const n = size / 40
+	b := make([]byte, size)
+	// a pointer in the tail, where element 921 would start
+	binary.LittleEndian.PutUint64(b[n*40:], 0x100000)
+	d.AddObject(0x10000, elem, TypeKindArray, b)
+	d.AddObject(0x100000, nil, TypeKindObject, make([]byte, 16))
+	d.Link()
+
+	x := d.FindObj(0x10000)
+	ft := d.Ft(x)
+	if len(ft.Fields) != 2*n {
+		t.Errorf("got %d fields, want %d", len(ft.Fields), 2*n)
+	}
+	for _, f := range ft.Fields {
+		if f.Offset+d.PtrSize > n*40 {
+			t.Errorf("field %s at offset %d is past the last whole element", f.Name, f.Offset)
+		}
+	}
+	if e := d.Edges(x); len(e) != 0 {
+		t.Errorf("got edges %v from the tail, want none", e)
+	}
+}
+
+// TestIfaceEdges checks the edges out of interfaces, in objects and in
+// roots: a data word is an edge if the dump says it is a pointer, and
+// a type or itab word is an edge if it points into the heap.
+func TestIfaceEdges(t *testing.T) {
+	order := binary.LittleEndian
+	d := NewDump(order, 8)
+	holder := d.AddType("main.holder", 64, []Field{
+		{Kind: FieldKindEface, Offset: 0, Name: "direct"},
+		{Kind: FieldKindEface, Offset: 16, Name: "indirect"},
+		{Kind: FieldKindEface, Offset: 32, Name: "value"},
+		{Kind: FieldKindIface, Offset: 48, Name: "iface"},
+	})
+	ptrT := d.AddType("*main.T", 8, nil)
+	ptrT.efaceptr = true // a *T is stored directly in the data word
+	boxedInt := d.AddType("int", 8, nil)
+	boxedInt.efaceptr = true // the data word points to a copy of the int
+	directInt := d.AddType("main.word", 8, nil)
+	directInt.efaceptr = false // the data word holds the value itself
+	d.ItabMap[0x4000] = true
+
+	b := make([]byte, 64)
+	order.PutUint64(b[0:], ptrT.Addr)
+	order.PutUint64(b[8:], 0x2000)
+	order.PutUint64(b[16:], boxedInt.Addr)
+	order.PutUint64(b[24:], 0x3000)
+	order.PutUint64(b[32:], directInt.Addr)
+	order.PutUint64(b[40:], 0x2000) // looks like a pointer, but isn't
+	order.PutUint64(b[48:], 0x4000) // an itab in the heap
+	order.PutUint64(b[56:], 0x2008)
+	d.AddObject(0x1000, holder, TypeKindObject, b)
+	d.AddObject(0x2000, nil, TypeKindObject, make([]byte, 16)) // a T
+	d.AddObject(0x3000, nil, TypeKindObject, make([]byte, 8))  // the boxed int
+	d.AddObject(0x4000, nil, TypeKindObject, make([]byte, 32)) // the itab
+	d.Link()
+
+	want := []struct {
+		to                uint64
+		fromOff, toOffset uint64
+	}{
+		{0x2000, 8, 0},
+		{0x3000, 24, 0},
+		{0x4000, 48, 0},
+		{0x2000, 56, 8},
+	}
+	check := func(what string, edges []Edge) {
+		if len(edges) != len(want) {
+			t.Errorf("%s: got edges %v, want %d edges", what, edges, len(want))
+			return
+		}
+		for i, e := range edges {
+			w := want[i]
+			if d.Addr(e.To) != w.to || e.FromOffset != w.fromOff || e.ToOffset != w.toOffset {
+				t.Errorf("%s: edge %d is %v, want to %x from offset %d to offset %d", what, i, e, w.to, w.fromOff, w.toOffset)
+			}
+		}
+	}
+	check("object", d.Edges(d.FindObj(0x1000)))
+	// stack frames and globals use appendFields
+	check("root", d.appendFields(nil, b, holder.Fields))
+}
+
+// TestByteOrders writes dumps of each byte order and pointer size and
+// reads them back, which must give the same objects and edges whatever
+// the byte order of the machine running the test.
+func TestByteOrders(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for _, ps := range []uint64{4, 8} {
+			d := NewDump(order, ps)
+			put := func(b []byte, x uint64) {
+				if ps == 4 {
+					order.PutUint32(b, uint32(x))
+				} else {
+					order.PutUint64(b, x)
+				}
+			}
+			node := d.AddType("main.node", 3*ps, []Field{
+				{Kind: FieldKindPtr, Offset: 0, Name: "next"},
+				{Kind: FieldKindString, Offset: ps, Name: "s"},
+			})
+			mk := func(next, str, n uint64) []byte {
+				b := make([]byte, 4*ps) // rounded up to a size class
+				put(b, next)
+				put(b[ps:], str)
+				put(b[2*ps:], n)
+				return b
+			}
+			d.AddObject(0x1000, node, TypeKindObject, mk(0x1040, 0x1082, 3))
+			d.AddObject(0x1040, node, TypeKindObject, mk(0, 0x1080, 5))
+			d.AddObject(0x1080, nil, TypeKindObject, make([]byte, 8))
+			d.AddRoot("test", 0x1000)
+			d.Link()
+
+			var buf bytes.Buffer
+			if err := d.WriteRedactedDump(&buf); err != nil {
+				t.Fatal(err)
+			}
+			e := ReadStream(&buf, nil, nil)
+			if e.Order != order || e.PtrSize != ps {
+				t.Errorf("%v %d: read back as %v %d", order, ps, e.Order, e.PtrSize)
+				continue
+			}
+			if e.NumObjects() != d.NumObjects() {
+				t.Errorf("%v %d: got %d objects, want %d", order, ps, e.NumObjects(), d.NumObjects())
+				continue
+			}
+			for i := 0; i < d.NumObjects(); i++ {
+				x := ObjId(i)
+				if !bytes.Equal(e.Contents(x), d.Contents(x)) {
+					t.Errorf("%v %d: object %d has contents %v, want %v", order, ps, i, e.Contents(x), d.Contents(x))
+				}
+				// field names aren't in the dump, so only compare the rest
+				if got, want := edgeTargets(e.Edges(x)), edgeTargets(d.Edges(x)); !reflect.DeepEqual(got, want) {
+					t.Errorf("%v %d: object %d has edges %v, want %v", order, ps, i, got, want)
+				}
+			}
+			if got, want := e.Reachable().Count(), 3; got != want {
+				t.Errorf("%v %d: %d objects reachable, want %d", order, ps, got, want)
+			}
+		}
+	}
+}
+
+func edgeTargets(edges []Edge) []Edge {
+	var r []Edge
+	for _, e := range edges {
+		e.FieldName = ""
+		r = append(r, e)
+	}
+	return r
+}
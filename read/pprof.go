@@ -0,0 +1,186 @@
+package read
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteGoroutineProfile writes the goroutines of the dump to w as a
+// goroutine profile in the (gzipped protocol buffer) format used by
+// pprof, so they can be explored with "go tool pprof".  Goroutines
+// with the same stack, by function name, are counted together.
+func (d *Dump) WriteGoroutineProfile(w io.Writer) error {
+	p := &profileBuilder{strings: map[string]int{"": 0}, strtab: []string{""}}
+
+	// group goroutines by stack
+	type group struct {
+		stack []*StackFrame
+		count int64
+	}
+	var groups []*group
+	m := map[string]*group{}
+	for _, g := range d.Goroutines {
+		var stack []*StackFrame
+		var names []string
+		for f := g.Bos; f != nil; f = f.Parent {
+			stack = append(stack, f)
+			names = append(names, f.Name)
+		}
+		k := strings.Join(names, "\n")
+		gr := m[k]
+		if gr == nil {
+			gr = &group{stack: stack}
+			m[k] = gr
+			groups = append(groups, gr)
+		}
+		gr.count++
+	}
+
+	var b protobuf
+	st := p.valueType("goroutine", "count")
+	b.bytes(1, st)
+	for _, gr := range groups {
+		var s protobuf
+		var locs []uint64
+		for _, f := range gr.stack {
+			locs = append(locs, p.location(f))
+		}
+		s.uint64s(1, locs)
+		s.uint64s(2, []uint64{uint64(gr.count)})
+		b.bytes(2, s)
+	}
+	b = append(b, p.locs...)
+	b = append(b, p.funcs...)
+	for _, s := range p.strtab {
+		b.string(6, s)
+	}
+	b.bytes(11, st)
+	b.uint64(12, 1)
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(b); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// WriteFoldedStacks writes the goroutine stacks of the dump to w in
+// the folded format read by flamegraph.pl and speedscope: one line per
+// distinct stack, with the function names from the outermost call in,
+// separated by semicolons, followed by a space and the number of
+// goroutines with that stack.  The most common stacks come first.
+func (d *Dump) WriteFoldedStacks(w io.Writer) error {
+	m := map[string]int{}
+	for _, g := range d.Goroutines {
+		var names []string
+		for f := g.Bos; f != nil; f = f.Parent {
+			names = append(names, f.Name)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		// reverse so the stack starts at the outermost frame
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+		m[strings.Join(names, ";")]++
+	}
+	b := bufio.NewWriter(w)
+	for _, t := range sortedTallies(m) {
+		fmt.Fprintf(b, "%s %d\n", t.Name, t.Count)
+	}
+	return b.Flush()
+}
+
+// profileBuilder accumulates the tables of a pprof profile.
+type profileBuilder struct {
+	strings map[string]int
+	strtab  []string
+	funcIds map[string]uint64
+	locIds  map[string]uint64
+	funcs   protobuf // encoded Function messages
+	locs    protobuf // encoded Location messages
+}
+
+func (p *profileBuilder) str(s string) uint64 {
+	i, ok := p.strings[s]
+	if !ok {
+		i = len(p.strtab)
+		p.strings[s] = i
+		p.strtab = append(p.strtab, s)
+	}
+	return uint64(i)
+}
+
+func (p *profileBuilder) valueType(typ, unit string) protobuf {
+	var b protobuf
+	b.uint64(1, p.str(typ))
+	b.uint64(2, p.str(unit))
+	return b
+}
+
+// location returns the id of the Location for frame f's function.
+func (p *profileBuilder) location(f *StackFrame) uint64 {
+	if id, ok := p.locIds[f.Name]; ok {
+		return id
+	}
+	if p.funcIds == nil {
+		p.funcIds = map[string]uint64{}
+		p.locIds = map[string]uint64{}
+	}
+	fid := uint64(len(p.funcIds) + 1)
+	p.funcIds[f.Name] = fid
+	var fn protobuf
+	fn.uint64(1, fid)
+	fn.uint64(2, p.str(f.Name))
+	fn.uint64(3, p.str(f.Name))
+	p.funcs.bytes(5, fn)
+
+	id := uint64(len(p.locIds) + 1)
+	p.locIds[f.Name] = id
+	var line protobuf
+	line.uint64(1, fid)
+	var loc protobuf
+	loc.uint64(1, id)
+	loc.uint64(3, f.pc)
+	loc.bytes(4, line)
+	p.locs.bytes(4, loc)
+	return id
+}
+
+// protobuf is an encoded protocol buffer message.
+type protobuf []byte
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protobuf) uint64s(field int, x []uint64) {
+	var p protobuf
+	for _, v := range x {
+		p.varint(v)
+	}
+	b.bytes(field, p)
+}
+
+func (b *protobuf) bytes(field int, x []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(x)))
+	*b = append(*b, x...)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
@@ -0,0 +1,177 @@
+package read
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maximum number of bytes of a string to show inline
+const maxStringShown = 64
+
+// An AddrStyle says how FormatAddr formats addresses.
+type AddrStyle int
+
+const (
+	AddrHex       AddrStyle = iota // 0x-prefixed hex, e.g. 0xc208001000
+	AddrHexPadded                  // 0x-prefixed hex, padded to the pointer size, e.g. 0x000000c208001000
+	AddrDecimal                    // decimal, e.g. 833357877248
+)
+
+// AddressStyle is the style used for addresses in everything this
+// package prints.
+var AddressStyle = AddrHex
+
+// FormatAddr returns the address a formatted in AddressStyle.  After
+// NormalizeAddresses, heap addresses are formatted relative to the
+// start of the heap, e.g. heap+0x1000.
+func (d *Dump) FormatAddr(a uint64) string {
+	if d.normalized && a >= d.HeapStart && a < d.HeapEnd {
+		return "heap+" + formatAddr(a-d.HeapStart, d.PtrSize)
+	}
+	return formatAddr(a, d.PtrSize)
+}
+
+func formatAddr(a, ptrSize uint64) string {
+	switch AddressStyle {
+	case AddrHexPadded:
+		return fmt.Sprintf("0x%0*x", 2*ptrSize, a)
+	case AddrDecimal:
+		return strconv.FormatUint(a, 10)
+	}
+	return fmt.Sprintf("0x%x", a)
+}
+
+// NormalizeAddresses makes heap addresses relative to HeapStart in
+// everything printed from then on, so the output for two runs of a
+// program can be compared even though the heap was mapped at different
+// addresses.  Addr and the other accessors still return the original
+// addresses; NormalizedAddr converts them.
+func (d *Dump) NormalizeAddresses() {
+	d.normalized = true
+}
+
+// NormalizedAddr returns heap address a relative to HeapStart.  Other
+// addresses (stacks, globals, ...) are returned unchanged.
+func (d *Dump) NormalizedAddr(a uint64) uint64 {
+	if a >= d.HeapStart && a < d.HeapEnd {
+		return a - d.HeapStart
+	}
+	return a
+}
+
+// ObjSummary returns a one-line description of object x: its
+// address, type, and size.
+func (d *Dump) ObjSummary(x ObjId) string {
+	return fmt.Sprintf("%s %s (%d bytes)", d.FormatAddr(d.Addr(x)), d.Ft(x).Name, d.Size(x))
+}
+
+// ObjString returns a human-readable description of object x: its
+// type, address and size, followed by one line per field giving the
+// field name and its value.  Pointers are shown as the object they
+// point to, strings are shown inline, and slices are shown as their
+// pointer, length, and capacity.  If the contents were dropped after
+// reading (see Options.DropDataAfterLink), a notice saying so takes
+// the place of the fields.
+func (d *Dump) ObjString(x ObjId) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %s : %s (%d bytes)\n", d.FormatAddr(d.Addr(x)), d.Ft(x).Name, d.Size(x))
+
+	b := d.Contents(x)
+	if b == nil && !d.HasContents() {
+		buf.WriteString("  (data dropped, see Options.DropDataAfterLink)\n")
+		return buf.String()
+	}
+	edges := map[uint64]Edge{}
+	for _, e := range d.Edges(x) {
+		edges[e.FromOffset] = e
+	}
+
+	for _, f := range d.Ft(x).Fields {
+		off := f.Offset
+		if off >= uint64(len(b)) {
+			continue
+		}
+		fmt.Fprintf(&buf, "  %s: %s\n", f.Name, d.fieldValue(b, f, edges))
+	}
+	return buf.String()
+}
+
+// ptrString returns a representation of the pointer at data[off:].
+func (d *Dump) ptrString(data []byte, off uint64, edges map[uint64]Edge) string {
+	if e, ok := edges[off]; ok {
+		s := fmt.Sprintf("object %s (%s)", d.FormatAddr(d.Addr(e.To)), d.Ft(e.To).Name)
+		if e.ToOffset != 0 {
+			s = fmt.Sprintf("%s+%d", s, e.ToOffset)
+		}
+		return s
+	}
+	p := readPtr(d, data[off:])
+	if p == 0 {
+		return "nil"
+	}
+	return fmt.Sprintf("%s (outside heap)", d.FormatAddr(p))
+}
+
+// fieldValue returns a representation of the value of field f in data.
+func (d *Dump) fieldValue(data []byte, f Field, edges map[uint64]Edge) string {
+	b := data[f.Offset:]
+	switch f.Kind {
+	case FieldKindBool:
+		return strconv.FormatBool(b[0] != 0)
+	case FieldKindUInt8:
+		return fmt.Sprintf("%d", b[0])
+	case FieldKindSInt8:
+		return fmt.Sprintf("%d", int8(b[0]))
+	case FieldKindUInt16:
+		return fmt.Sprintf("%d", d.Order.Uint16(b))
+	case FieldKindSInt16:
+		return fmt.Sprintf("%d", int16(d.Order.Uint16(b)))
+	case FieldKindUInt32:
+		return fmt.Sprintf("%d", d.Order.Uint32(b))
+	case FieldKindSInt32:
+		return fmt.Sprintf("%d", int32(d.Order.Uint32(b)))
+	case FieldKindUInt64:
+		return fmt.Sprintf("%d", d.Order.Uint64(b))
+	case FieldKindSInt64:
+		return fmt.Sprintf("%d", int64(d.Order.Uint64(b)))
+	case FieldKindFloat32:
+		return fmt.Sprintf("%g", math.Float32frombits(d.Order.Uint32(b)))
+	case FieldKindFloat64:
+		return fmt.Sprintf("%g", math.Float64frombits(d.Order.Uint64(b)))
+	case FieldKindComplex64:
+		return fmt.Sprintf("(%g+%gi)", math.Float32frombits(d.Order.Uint32(b)), math.Float32frombits(d.Order.Uint32(b[4:])))
+	case FieldKindComplex128:
+		return fmt.Sprintf("(%g+%gi)", math.Float64frombits(d.Order.Uint64(b)), math.Float64frombits(d.Order.Uint64(b[8:])))
+	case FieldKindBytes8:
+		return fmt.Sprintf("% x", b[:8])
+	case FieldKindBytes16:
+		return fmt.Sprintf("% x", b[:16])
+	case FieldKindBytesElided:
+		return fmt.Sprintf("... %d elided bytes ...", len(b))
+	case FieldKindPtr:
+		return d.ptrString(data, f.Offset, edges)
+	case FieldKindString:
+		n := readPtr(d, b[d.PtrSize:])
+		if e, ok := edges[f.Offset]; ok {
+			s := d.Contents(e.To)[e.ToOffset:]
+			if uint64(len(s)) > n {
+				s = s[:n]
+			}
+			if len(s) > maxStringShown {
+				return fmt.Sprintf("%q... (len %d)", s[:maxStringShown], n)
+			}
+			return strconv.Quote(string(s))
+		}
+		return fmt.Sprintf("%s (len %d)", d.ptrString(data, f.Offset, edges), n)
+	case FieldKindSlice:
+		return fmt.Sprintf("%s (len %d cap %d)", d.ptrString(data, f.Offset, edges), readPtr(d, b[d.PtrSize:]), readPtr(d, b[2*d.PtrSize:]))
+	case FieldKindIface, FieldKindEface:
+		if readPtr(d, b) == 0 {
+			return "nil"
+		}
+		return d.ptrString(data, f.Offset+d.PtrSize, edges)
+	}
+	return "?"
+}
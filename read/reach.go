@@ -0,0 +1,777 @@
+package read
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Note: heaps can contain very long chains of objects (e.g. a linked
+// list with millions of entries), so all traversals in this package
+// use an explicit queue or stack instead of recursion.
+
+// forEachRootEdge calls fn for each edge from a root into the heap.
+// Roots are stack frames, globals, goroutines, other roots, and
+// queued finalizers.
+func (d *Dump) forEachRootEdge(fn func(e Edge)) {
+	d.forEachNonFinalizerRootEdge(fn)
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			fn(e)
+		}
+	}
+}
+
+// forEachNonFinalizerRootEdge is like forEachRootEdge, but skips the
+// edges from queued finalizers.
+func (d *Dump) forEachNonFinalizerRootEdge(fn func(e Edge)) {
+	for _, f := range d.Frames {
+		for _, e := range f.Edges {
+			fn(e)
+		}
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		for _, e := range x.Edges {
+			fn(e)
+		}
+	}
+	for _, g := range d.Goroutines {
+		for _, e := range g.Edges {
+			fn(e)
+		}
+	}
+	for _, r := range d.Otherroots {
+		for _, e := range r.Edges {
+			fn(e)
+		}
+	}
+}
+
+// Reachable returns the set of objects reachable from the roots.
+func (d *Dump) Reachable() Bitset {
+	d.liveOnce.Do(d.computeLive)
+	return append(Bitset(nil), d.live...)
+}
+
+// IsReachable reports whether object x is reachable from the roots.
+// The reachable set is computed on the first call and cached.
+func (d *Dump) IsReachable(x ObjId) bool {
+	d.liveOnce.Do(d.computeLive)
+	return d.live.Has(x)
+}
+
+func (d *Dump) computeLive() {
+	d.live, _ = d.ReachableDepth(0)
+}
+
+// FindLiveObj is like FindObj, but also reports whether the object
+// found is reachable from the roots.  A pointer to a dead object is
+// stale, or is hidden from the garbage collector.
+func (d *Dump) FindLiveObj(addr uint64) (ObjId, bool) {
+	x := d.FindObj(addr)
+	if x == ObjNil {
+		return ObjNil, false
+	}
+	return x, d.IsReachable(x)
+}
+
+// ReachableDepth is like Reachable, but only follows paths of at most
+// maxDepth edges from a root, counting the edge from the root itself,
+// so a maxDepth of 1 gives just the objects the roots point to.  A
+// maxDepth of 0 means no limit.
+// truncated reports whether there were objects left unexplored because
+// of the limit.
+func (d *Dump) ReachableDepth(maxDepth int) (reachable Bitset, truncated bool) {
+	reachable = NewBitset(d.NumObjects())
+	var q []ObjId
+	d.forEachRootEdge(func(e Edge) {
+		if !reachable.Has(e.To) {
+			reachable.Add(e.To)
+			q = append(q, e.To)
+		}
+	})
+	return reachable, d.mark(q, reachable, 1, maxDepth)
+}
+
+// SetNonRetainingTypes makes reachability, dominators, and retained
+// sizes ignore the edges out of objects whose full type name is one of
+// names, as if those objects didn't retain anything.  Pretending that
+// a known, dominant retainer doesn't exist shows what else keeps its
+// objects alive.  A nil names restores the normal behavior.  The
+// cached analyses are discarded.
+func (d *Dump) SetNonRetainingTypes(names []string) {
+	d.nonRetaining = nil
+	if len(names) > 0 {
+		m := map[string]bool{}
+		for _, n := range names {
+			m[n] = true
+		}
+		d.nonRetaining = make([]bool, len(d.FTList))
+		for i, ft := range d.FTList {
+			d.nonRetaining[i] = m[ft.Name]
+		}
+	}
+	d.InvalidateAnalysis()
+}
+
+// liveEdges returns the edges the liveness analyses follow out of x.
+// They are the edges of x, plus (see Options.ConservativeUntyped)
+// every word of an untyped x which points into the heap.  Objects of
+// non-retaining types (see SetNonRetainingTypes) have none.
+func (d *Dump) liveEdges(x ObjId) []Edge {
+	ft := d.Ft(x)
+	if int(ft.Id) < len(d.nonRetaining) && d.nonRetaining[ft.Id] {
+		return nil
+	}
+	e := d.edges(x)
+	if !d.conservative || ft.Typ != nil || ft.Kind != TypeKindObject {
+		return e
+	}
+	e = e[:len(e):len(e)] // append to a copy, not to the edge index
+	b := d.Contents(x)
+	for off := uint64(0); off+d.PtrSize <= uint64(len(b)); off += d.PtrSize {
+		p := readPtr(d, b[off:])
+		if y := d.FindObj(p); y != ObjNil {
+			e = append(e, Edge{y, off, p - d.Addr(y), ""})
+		}
+	}
+	return e
+}
+
+// ReachableWithRoots is like Reachable, but also treats the objects
+// containing the addresses in extra as roots.  This is useful for
+// pointers the dump doesn't know about, e.g. ones held in registers
+// or by C code.  Addresses outside the heap are ignored.
+func (d *Dump) ReachableWithRoots(extra []uint64) Bitset {
+	reachable := NewBitset(d.NumObjects())
+	var q []ObjId
+	add := func(x ObjId) {
+		if x != ObjNil && !reachable.Has(x) {
+			reachable.Add(x)
+			q = append(q, x)
+		}
+	}
+	d.forEachRootEdge(func(e Edge) { add(e.To) })
+	for _, a := range extra {
+		add(d.FindObj(a))
+	}
+	d.mark(q, reachable, 0, 0)
+	return reachable
+}
+
+// AddRoots adds an OtherRoot with the description "extra root" for
+// each of the addresses in addrs which points into the heap, so that
+// all analyses, including the dominator tree and retained sizes, treat
+// the objects there as live.  AddRoots modifies d, so it must not be
+// called concurrently with anything else.
+func (d *Dump) AddRoots(addrs []uint64) {
+	for _, a := range addrs {
+		x := d.FindObj(a)
+		if x == ObjNil {
+			continue
+		}
+		d.Otherroots = append(d.Otherroots, &OtherRoot{
+			Description: "extra root",
+			Edges:       []Edge{{x, 0, a - d.Addr(x), ""}},
+			toaddr:      a,
+			synthetic:   true,
+		})
+	}
+	d.InvalidateAnalysis()
+}
+
+// mark adds to mark every object reachable from the objects in q,
+// which must already be in mark and are depth edges from the start of
+// the search, using paths of at most maxDepth edges from the start (0
+// means no limit).  It reports whether the search was cut short by the
+// depth limit.
+func (d *Dump) mark(q []ObjId, mark Bitset, depth, maxDepth int) bool {
+	// breadth-first search, one depth level at a time
+	var next []ObjId
+	for ; len(q) > 0; depth++ {
+		if maxDepth > 0 && depth >= maxDepth {
+			// see if there is anything we didn't get to
+			for _, x := range q {
+				for _, e := range d.liveEdges(x) {
+					if !mark.Has(e.To) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		next = next[:0]
+		for _, x := range q {
+			for _, e := range d.liveEdges(x) {
+				if !mark.Has(e.To) {
+					mark.Add(e.To)
+					next = append(next, e.To)
+				}
+			}
+		}
+		q, next = next, q
+	}
+	return false
+}
+
+// MaxDepthFromRoots returns the reachable object furthest from the
+// roots, as the shortest path to it: path starts with an object
+// pointed to directly by a root and ends with the deepest object, and
+// depth is len(path).  A deep structure, like a linked list with
+// millions of entries, often means unbounded growth.  Shortcuts count,
+// so a list whose entries all point back to its head is not deep.
+func (d *Dump) MaxDepthFromRoots() (depth int, path []ObjId) {
+	parent := make([]ObjId, d.NumObjects())
+	seen := NewBitset(d.NumObjects())
+	var q []ObjId
+	d.forEachRootEdge(func(e Edge) {
+		if !seen.Has(e.To) {
+			seen.Add(e.To)
+			parent[e.To] = ObjNil
+			q = append(q, e.To)
+		}
+	})
+	if len(q) == 0 {
+		return 0, nil
+	}
+	// breadth-first search; the last object found is the deepest
+	for i := 0; i < len(q); i++ {
+		for _, e := range d.liveEdges(q[i]) {
+			if !seen.Has(e.To) {
+				seen.Add(e.To)
+				parent[e.To] = q[i]
+				q = append(q, e.To)
+			}
+		}
+	}
+	for x := q[len(q)-1]; x != ObjNil; x = parent[x] {
+		path = append(path, x)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return len(path), path
+}
+
+// FinalizerRetained returns the objects which are alive only because
+// of finalizers: objects that are reachable from queued finalizers, or
+// from objects with a registered finalizer, but not from any other
+// root.  A large result means finalizers are falling behind, which
+// makes the heap grow.
+func (d *Dump) FinalizerRetained() []ObjId {
+	n := d.NumObjects()
+	live := NewBitset(n)
+	var q []ObjId
+	d.forEachNonFinalizerRootEdge(func(e Edge) {
+		if !live.Has(e.To) {
+			live.Add(e.To)
+			q = append(q, e.To)
+		}
+	})
+	d.mark(q, live, 0, 0)
+
+	fin := append(Bitset(nil), live...)
+	q = q[:0]
+	add := func(x ObjId) {
+		if !fin.Has(x) {
+			fin.Add(x)
+			q = append(q, x)
+		}
+	}
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			add(e.To)
+		}
+	}
+	// The collector keeps everything an object with a finalizer
+	// points to alive, but not the object itself.
+	for _, f := range d.Finalizers {
+		if x := d.FindObj(f.obj); x != ObjNil {
+			for _, e := range d.liveEdges(x) {
+				add(e.To)
+			}
+		}
+	}
+	d.mark(q, fin, 0, 0)
+
+	var r []ObjId
+	for i := 0; i < n; i++ {
+		if fin.Has(ObjId(i)) && !live.Has(ObjId(i)) {
+			r = append(r, ObjId(i))
+		}
+	}
+	return r
+}
+
+// A Root is an edge from a root into the heap.
+type Root struct {
+	Desc string // what the root is, e.g. "global main.cache"
+	Edge Edge
+}
+
+// RootsReaching returns every root edge from which x can be reached.
+// Unlike a single path, this finds all of the globals, stack
+// variables, etc. which are keeping x alive.
+func (d *Dump) RootsReaching(x ObjId) []Root {
+	// walk backwards from x to find everything that reaches it
+	idx, ref := d.reverseEdges()
+	reaches := NewBitset(d.NumObjects())
+	reaches.Add(x)
+	q := []ObjId{x}
+	for len(q) > 0 {
+		y := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, z := range ref[idx[y]:idx[y+1]] {
+			if !reaches.Has(z) {
+				reaches.Add(z)
+				q = append(q, z)
+			}
+		}
+	}
+
+	var r []Root
+	for _, f := range d.Frames {
+		for _, e := range f.Edges {
+			if reaches.Has(e.To) {
+				desc := fmt.Sprintf("local %s.%s", f.Name, e.FieldName)
+				if f.Goroutine != nil {
+					desc += " in goroutine " + d.FormatAddr(f.Goroutine.Addr)
+				}
+				r = append(r, Root{desc, e})
+			}
+		}
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		for _, e := range x.Edges {
+			if reaches.Has(e.To) {
+				r = append(r, Root{"global " + e.FieldName, e})
+			}
+		}
+	}
+	for _, g := range d.Goroutines {
+		for _, e := range g.Edges {
+			if reaches.Has(e.To) {
+				r = append(r, Root{fmt.Sprintf("goroutine %s %s", d.FormatAddr(g.Addr), e.FieldName), e})
+			}
+		}
+	}
+	for _, o := range d.Otherroots {
+		for _, e := range o.Edges {
+			if reaches.Has(e.To) {
+				r = append(r, Root{o.Description, e})
+			}
+		}
+	}
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			if reaches.Has(e.To) {
+				r = append(r, Root{"queued finalizer", e})
+			}
+		}
+	}
+	return r
+}
+
+// ReachableTypes returns the distinct types of the objects reachable
+// from root, sorted by name.  It is a compact summary of the data a
+// subsystem holds on to, which can show a type that has no business
+// being there.  Objects with no type are not counted.
+func (d *Dump) ReachableTypes(root Root) []*Type {
+	seen := NewBitset(d.NumObjects())
+	seen.Add(root.Edge.To)
+	d.mark([]ObjId{root.Edge.To}, seen, 0, 0)
+	m := map[*Type]bool{}
+	var r []*Type
+	for _, x := range seen.Objects() {
+		if t := d.Ft(x).Typ; t != nil && !m[t] {
+			m[t] = true
+			r = append(r, t)
+		}
+	}
+	sort.Sort(typesByName(r))
+	return r
+}
+
+// Walk does a depth-first traversal of the objects reachable from the
+// objects in start, calling visit once for each object.  depth is the
+// length of the path the walk took from a start object to x, which
+// need not be the shortest path.  If visit
+// returns false, the objects x points to are not visited (through x;
+// they may still be visited through some other path).
+func (d *Dump) Walk(start []ObjId, visit func(x ObjId, depth int) bool) {
+	type entry struct {
+		x     ObjId
+		depth int
+	}
+	seen := NewBitset(d.NumObjects())
+	var stack []entry
+	for i := len(start) - 1; i >= 0; i-- {
+		stack = append(stack, entry{start[i], 0})
+	}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen.Has(e.x) {
+			continue
+		}
+		seen.Add(e.x)
+		if !visit(e.x, e.depth) {
+			continue
+		}
+		// push in reverse so edges are visited in field order
+		edges := d.Edges(e.x)
+		for i := len(edges) - 1; i >= 0; i-- {
+			if !seen.Has(edges[i].To) {
+				stack = append(stack, entry{edges[i].To, e.depth + 1})
+			}
+		}
+	}
+}
+
+// reverseEdges returns the objects that point to each object, in
+// compressed form: the objects pointing to x are ref[idx[x]:idx[x+1]].
+// An object appears once for each of its edges to x.  The result is
+// computed once and shared, so callers must not modify it.
+func (d *Dump) reverseEdges() (idx []int, ref []ObjId) {
+	d.revOnce.Do(func() {
+		d.revidx, d.rev = d.buildReverseEdges(d.edges)
+	})
+	return d.revidx, d.rev
+}
+
+// liveReverseEdges is like reverseEdges, but leaves out the edges
+// which don't retain anything, see liveEdges.  It is for the
+// traversals which compute what keeps objects alive.
+func (d *Dump) liveReverseEdges() (idx []int, ref []ObjId) {
+	if !d.conservative && d.nonRetaining == nil {
+		return d.reverseEdges()
+	}
+	d.liveRevOnce.Do(func() {
+		d.liveRevidx, d.liveRev = d.buildReverseEdges(d.liveEdges)
+	})
+	return d.liveRevidx, d.liveRev
+}
+
+func (d *Dump) buildReverseEdges(edges func(ObjId) []Edge) (idx []int, ref []ObjId) {
+	n := d.NumObjects()
+	idx = make([]int, n+1)
+	for i := 0; i < n; i++ {
+		for _, e := range edges(ObjId(i)) {
+			idx[e.To+1]++
+		}
+	}
+	for i := 0; i < n; i++ {
+		idx[i+1] += idx[i]
+	}
+	ref = make([]ObjId, idx[n])
+	next := append([]int(nil), idx[:n]...)
+	for i := 0; i < n; i++ {
+		for _, e := range edges(ObjId(i)) {
+			ref[next[e.To]] = ObjId(i)
+			next[e.To]++
+		}
+	}
+	return idx, ref
+}
+
+// OrphanObjects returns the objects which nothing points to: no other
+// object, and no root.  The garbage collector hasn't freed them yet,
+// which is normal for a few, but lots of them can mean the dump was
+// misparsed and edges are missing.
+func (d *Dump) OrphanObjects() []ObjId {
+	idx, _ := d.reverseEdges()
+	rooted := NewBitset(d.NumObjects())
+	d.forEachRootEdge(func(e Edge) {
+		rooted.Add(e.To)
+	})
+	var r []ObjId
+	for i := 0; i < d.NumObjects(); i++ {
+		if idx[i] == idx[i+1] && !rooted.Has(ObjId(i)) {
+			r = append(r, ObjId(i))
+		}
+	}
+	return r
+}
+
+// ChainLength returns the number of objects in the chain starting at
+// start and following the field named fieldName (e.g. "next") from
+// each object to the next.  The chain ends at an object whose field is
+// nil or doesn't point into the heap, or when it reaches an object
+// already in the chain.
+func (d *Dump) ChainLength(start ObjId, fieldName string) int {
+	seen := map[ObjId]bool{}
+	for x := start; !seen[x]; {
+		seen[x] = true
+		e, ok := d.EdgeByField(x, fieldName)
+		if !ok {
+			break
+		}
+		x = e.To
+	}
+	return len(seen)
+}
+
+// PathBetween returns a shortest path of edges leading from object
+// from to object to.  The first edge leaves from, and each following
+// edge leaves the target of the one before it.  It returns false if to
+// is not reachable from from.
+func (d *Dump) PathBetween(from, to ObjId) ([]Edge, bool) {
+	if from == to {
+		return nil, true
+	}
+	// breadth-first search, remembering the edge used to reach
+	// each object and where it came from
+	type step struct {
+		e    Edge
+		prev ObjId
+	}
+	steps := map[ObjId]step{from: {prev: ObjNil}}
+	q := []ObjId{from}
+	for len(q) > 0 {
+		x := q[0]
+		q = q[1:]
+		for _, e := range d.Edges(x) {
+			if _, ok := steps[e.To]; ok {
+				continue
+			}
+			steps[e.To] = step{e, x}
+			if e.To != to {
+				q = append(q, e.To)
+				continue
+			}
+			var path []Edge
+			for y := to; y != from; y = steps[y].prev {
+				path = append(path, steps[y].e)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, true
+		}
+	}
+	return nil, false
+}
+
+// PathToRootVia returns a shortest path from a root to x which goes
+// through an object of the named type (matched like RetentionGraph
+// does).  The first edge is from the root, and each following edge
+// leaves the target of the one before it.  x itself counts as being
+// on the path.  It returns false if there is no such path, e.g. to
+// check that x isn't held by some cache type.
+func (d *Dump) PathToRootVia(x ObjId, viaType string) ([]Edge, bool) {
+	is := func(y ObjId) bool {
+		ft := d.Ft(y)
+		return ft.Name == viaType || ft.Typ != nil && ft.Typ.Name == viaType
+	}
+	// Breadth-first search over states (object, whether the path
+	// to it went through viaType), which are numbered 2*obj+via.
+	type step struct {
+		e    Edge
+		prev int // previous state, -1 for a root
+	}
+	n := d.NumObjects()
+	steps := make([]step, 2*n)
+	seen := NewBitset(2 * n)
+	var q []int
+	visit := func(e Edge, prev int, via bool) {
+		s := 2 * int(e.To)
+		if via || is(e.To) {
+			s++
+		}
+		if !seen.Has(ObjId(s)) {
+			seen.Add(ObjId(s))
+			steps[s] = step{e, prev}
+			q = append(q, s)
+		}
+	}
+	d.forEachRootEdge(func(e Edge) {
+		visit(e, -1, false)
+	})
+	goal := 2*int(x) + 1
+	for i := 0; i < len(q) && !seen.Has(ObjId(goal)); i++ {
+		s := q[i]
+		for _, e := range d.Edges(ObjId(s / 2)) {
+			visit(e, s, s%2 == 1)
+		}
+	}
+	if !seen.Has(ObjId(goal)) {
+		return nil, false
+	}
+	var path []Edge
+	for s := goal; s != -1; s = steps[s].prev {
+		path = append(path, steps[s].e)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, true
+}
+
+// ReachableFromGlobal returns the objects reachable from the global
+// variable with the given name, e.g. "main.serverState", in increasing
+// ObjId order.  Pointers in fields of the global (e.g.
+// main.serverState.conns) count as part of it.
+func (d *Dump) ReachableFromGlobal(name string) []ObjId {
+	mark := NewBitset(d.NumObjects())
+	var q []ObjId
+	for _, x := range []*Data{d.Data, d.Bss} {
+		for _, e := range x.Edges {
+			if !isGlobalField(e.FieldName, name) || mark.Has(e.To) {
+				continue
+			}
+			mark.Add(e.To)
+			q = append(q, e.To)
+		}
+	}
+	d.mark(q, mark, 0, 0)
+	return mark.Objects()
+}
+
+// isGlobalField reports whether field, the name of a pointer slot in
+// the data or bss sections, is part of the global variable name.
+func isGlobalField(field, name string) bool {
+	if !strings.HasPrefix(field, name) {
+		return false
+	}
+	if len(field) == len(name) {
+		return true
+	}
+	// fields are named name.field, or name:offset if not known exactly
+	c := field[len(name)]
+	return c == '.' || c == ':'
+}
+
+// A Component is a set of unreachable objects connected by pointers.
+type Component struct {
+	Objects []ObjId // in increasing ObjId order
+	Bytes   uint64  // total size of Objects
+}
+
+// UnreachableComponents groups the unreachable objects into connected
+// components (ignoring the direction of edges) and returns the
+// components of at least minBytes bytes, largest first.  A big
+// unreachable component, like a detached cache, is much more
+// interesting than the scattered small garbage found in any heap.
+func (d *Dump) UnreachableComponents(minBytes uint64) []Component {
+	reachable := d.Reachable()
+
+	// union-find over unreachable objects
+	parent := make([]ObjId, d.NumObjects())
+	for i := range parent {
+		parent[i] = ObjId(i)
+	}
+	find := func(x ObjId) ObjId {
+		for parent[x] != x {
+			parent[x] = parent[parent[x]]
+			x = parent[x]
+		}
+		return x
+	}
+	for i := range d.objects {
+		if reachable.Has(ObjId(i)) {
+			continue
+		}
+		for _, e := range d.liveEdges(ObjId(i)) {
+			// Note: unreachable objects can only point to
+			// other unreachable objects or reachable ones,
+			// and reachable ones aren't part of any component.
+			if reachable.Has(e.To) {
+				continue
+			}
+			a, b := find(ObjId(i)), find(e.To)
+			if a != b {
+				parent[a] = b
+			}
+		}
+	}
+
+	m := map[ObjId]*Component{}
+	for i := range d.objects {
+		if reachable.Has(ObjId(i)) {
+			continue
+		}
+		r := find(ObjId(i))
+		c := m[r]
+		if c == nil {
+			c = &Component{}
+			m[r] = c
+		}
+		c.Objects = append(c.Objects, ObjId(i))
+		c.Bytes += d.objects[i].Ft.Size
+	}
+	var r []Component
+	for _, c := range m {
+		if c.Bytes >= minBytes {
+			r = append(r, *c)
+		}
+	}
+	sort.Sort(byComponentBytes(r))
+	return r
+}
+
+type byComponentBytes []Component
+
+func (a byComponentBytes) Len() int           { return len(a) }
+func (a byComponentBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byComponentBytes) Less(i, j int) bool { return a[i].Bytes > a[j].Bytes }
+
+// Objects larger than this are never reported by StackOnlyObjects.
+// The compiler won't implicitly stack allocate anything bigger.
+const maxStackOnlySize = 64 << 10
+
+// StackOnlyObjects returns the small objects which are referenced by
+// exactly one stack frame and by nothing else: no heap objects,
+// globals, other roots, or finalizers.  These objects might not have
+// needed to escape to the heap, so they are candidates for reducing
+// allocation.  This is only a hint; the compiler's escape analysis
+// may have had good reasons for each of them.
+func (d *Dump) StackOnlyObjects() []ObjId {
+	n := d.NumObjects()
+	idx, _ := d.reverseEdges()
+
+	// frame[x] is the one frame pointing to x, if any.
+	frame := make([]*StackFrame, n)
+	other := NewBitset(n) // pointed to by something other than frame[x]
+	for _, f := range d.Frames {
+		for _, e := range f.Edges {
+			if frame[e.To] != nil && frame[e.To] != f {
+				other.Add(e.To)
+			}
+			frame[e.To] = f
+		}
+	}
+	for _, x := range []*Data{d.Data, d.Bss} {
+		for _, e := range x.Edges {
+			other.Add(e.To)
+		}
+	}
+	for _, g := range d.Goroutines {
+		for _, e := range g.Edges {
+			other.Add(e.To)
+		}
+	}
+	for _, r := range d.Otherroots {
+		for _, e := range r.Edges {
+			other.Add(e.To)
+		}
+	}
+	for _, f := range d.QFinal {
+		for _, e := range f.Edges {
+			other.Add(e.To)
+		}
+	}
+	for _, f := range d.Finalizers {
+		if x := d.FindObj(f.obj); x != ObjNil {
+			other.Add(x)
+		}
+	}
+
+	var r []ObjId
+	for i := 0; i < n; i++ {
+		x := ObjId(i)
+		if frame[x] != nil && !other.Has(x) && idx[x] == idx[x+1] && d.Size(x) <= maxStackOnlySize {
+			r = append(r, x)
+		}
+	}
+	return r
+}
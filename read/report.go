@@ -0,0 +1,205 @@
+package read
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// number of entries in each section of a LeakReport
+const leakReportTop = 10
+
+// A LeakReport collects the findings of several analyses which
+// commonly point at memory leaks.  It is meant to be serialized (e.g.
+// with encoding/json) and consumed by other tools.  Objects are
+// identified by both ObjId and address, so a finding can be looked at
+// more closely afterwards.
+type LeakReport struct {
+	Retainers  []Retainer       // objects retaining the most memory
+	Goroutines []GoroutineGroup // goroutines blocked in the same place
+	Duplicates []DupStat        // duplicated strings and byte slices
+	SliceWaste []SliceWaste     // unused capacity of slices
+}
+
+// A Retainer is an object which keeps a lot of memory alive.
+type Retainer struct {
+	Obj      ObjId
+	Addr     string
+	Type     string
+	Size     uint64
+	Retained uint64   // see RetainedSize
+	Path     []string // dominator chain from a root down to the object
+	Roots    []string // roots from which the object can be reached
+}
+
+// A GoroutineGroup is a set of goroutines in the same state with the
+// same stack.  Lots of goroutines blocked at the same place are a
+// common kind of leak.
+type GoroutineGroup struct {
+	State      string
+	Stack      []string // function names, innermost first
+	Goroutines []string // goroutine addresses
+}
+
+// A SliceWaste reports the capacity beyond the length of all slices
+// of one element type.
+type SliceWaste struct {
+	Type   string // element type
+	Slices int
+	Wasted uint64 // bytes between len and cap
+}
+
+// LeakReport runs the analyses for a LeakReport.  It needs the
+// dominator tree, so it is expensive on big heaps.
+func (d *Dump) LeakReport() LeakReport {
+	r := LeakReport{
+		Retainers:  d.topRetainers(leakReportTop),
+		Goroutines: d.goroutineGroups(),
+		Duplicates: d.DuplicateBytes(),
+		SliceWaste: d.sliceWaste(),
+	}
+	if len(r.Duplicates) > leakReportTop {
+		r.Duplicates = r.Duplicates[:leakReportTop]
+	}
+	if len(r.SliceWaste) > leakReportTop {
+		r.SliceWaste = r.SliceWaste[:leakReportTop]
+	}
+	return r
+}
+
+// topRetainers returns the n objects with the largest retained size,
+// skipping objects dominated by one already chosen.
+func (d *Dump) topRetainers(n int) []Retainer {
+	objs := make([]ObjId, d.NumObjects())
+	for i := range objs {
+		objs[i] = ObjId(i)
+	}
+	sort.Sort(byRetainedSize{d, objs})
+	chosen := NewBitset(d.NumObjects())
+	var r []Retainer
+	for _, x := range objs {
+		if len(r) == n || d.RetainedSize(x) == 0 {
+			break
+		}
+		chain := d.DominatorChain(x)
+		dominated := false
+		for _, y := range chain {
+			if chosen.Has(y) {
+				dominated = true
+				break
+			}
+		}
+		if dominated {
+			continue
+		}
+		chosen.Add(x)
+		var path, roots []string
+		for _, y := range chain {
+			path = append(path, fmt.Sprintf("%s %s", d.FormatAddr(d.Addr(y)), d.Ft(y).Name))
+		}
+		for _, root := range d.RootsReaching(chain[0]) {
+			roots = append(roots, root.Desc)
+		}
+		r = append(r, Retainer{x, d.FormatAddr(d.Addr(x)), d.Ft(x).Name, d.Size(x), d.RetainedSize(x), path, roots})
+	}
+	return r
+}
+
+type byRetainedSize struct {
+	d    *Dump
+	objs []ObjId
+}
+
+func (a byRetainedSize) Len() int      { return len(a.objs) }
+func (a byRetainedSize) Swap(i, j int) { a.objs[i], a.objs[j] = a.objs[j], a.objs[i] }
+func (a byRetainedSize) Less(i, j int) bool {
+	return a.d.RetainedSize(a.objs[i]) > a.d.RetainedSize(a.objs[j])
+}
+
+// goroutineGroups returns the groups of at least two goroutines with
+// the same state and stack, biggest first.
+func (d *Dump) goroutineGroups() []GoroutineGroup {
+	m := map[string]*GoroutineGroup{}
+	for _, g := range d.Goroutines {
+		var stack []string
+		for f := g.Bos; f != nil; f = f.Parent {
+			stack = append(stack, f.Name)
+		}
+		state := g.StatusString()
+		k := state + "\n" + strings.Join(stack, "\n")
+		gg := m[k]
+		if gg == nil {
+			gg = &GoroutineGroup{State: state, Stack: stack}
+			m[k] = gg
+		}
+		gg.Goroutines = append(gg.Goroutines, d.FormatAddr(g.Addr))
+	}
+	var r []GoroutineGroup
+	for _, gg := range m {
+		if len(gg.Goroutines) > 1 {
+			r = append(r, *gg)
+		}
+	}
+	sort.Sort(byGroupSize(r))
+	if len(r) > leakReportTop {
+		r = r[:leakReportTop]
+	}
+	return r
+}
+
+type byGroupSize []GoroutineGroup
+
+func (a byGroupSize) Len() int      { return len(a) }
+func (a byGroupSize) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byGroupSize) Less(i, j int) bool {
+	if len(a[i].Goroutines) != len(a[j].Goroutines) {
+		return len(a[i].Goroutines) > len(a[j].Goroutines)
+	}
+	return a[i].State < a[j].State
+}
+
+// sliceWaste totals the unused capacity of the slices in heap objects
+// by element type, most waste first.  Only slices whose backing array
+// has a known type are counted.
+func (d *Dump) sliceWaste() []SliceWaste {
+	m := map[*Type]*SliceWaste{}
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		for _, f := range ft.Fields {
+			if f.Kind != FieldKindSlice || f.Offset+3*d.PtrSize > ft.Size {
+				continue
+			}
+			h := d.SliceAt(ObjId(i), f.Offset)
+			if h.ElemSize == 0 || h.Cap < h.Len {
+				continue
+			}
+			t := d.Ft(h.Backing).Typ
+			s := m[t]
+			if s == nil {
+				s = &SliceWaste{Type: t.Name}
+				m[t] = s
+			}
+			s.Slices++
+			s.Wasted += h.CapBytes() - h.UsedBytes()
+		}
+	}
+	var r []SliceWaste
+	for _, s := range m {
+		if s.Wasted > 0 {
+			r = append(r, *s)
+		}
+	}
+	sort.Sort(bySliceWaste(r))
+	return r
+}
+
+type bySliceWaste []SliceWaste
+
+func (a bySliceWaste) Len() int      { return len(a) }
+func (a bySliceWaste) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySliceWaste) Less(i, j int) bool {
+	if a[i].Wasted != a[j].Wasted {
+		return a[i].Wasted > a[j].Wasted
+	}
+	return a[i].Type < a[j].Type
+}
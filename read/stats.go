@@ -0,0 +1,858 @@
+package read
+
+import (
+	"debug/dwarf"
+	"hash/fnv"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// A Gap is a range of the heap which is not covered by any object.
+type Gap struct {
+	Addr uint64
+	Size uint64
+}
+
+// Fragmentation returns the number of bytes in [HeapStart,HeapEnd)
+// which are not covered by any object, along with the n largest such
+// gaps, largest first.  Free space between objects is a rough measure
+// of heap fragmentation: a heap with lots of small gaps can have a big
+// HeapSys while HeapAlloc is small.
+func (d *Dump) Fragmentation(n int) (uint64, []Gap) {
+	var total uint64
+	var gaps []Gap
+	add := func(addr, end uint64) {
+		if end <= addr {
+			return
+		}
+		g := Gap{addr, end - addr}
+		total += g.Size
+		if n <= 0 || len(gaps) == n && g.Size <= gaps[n-1].Size {
+			return
+		}
+		// insert in decreasing size order, dropping the smallest if full
+		if len(gaps) < n {
+			gaps = append(gaps, g)
+		}
+		i := len(gaps) - 1
+		for ; i > 0 && gaps[i-1].Size < g.Size; i-- {
+			gaps[i] = gaps[i-1]
+		}
+		gaps[i] = g
+	}
+
+	// objects are sorted by address, so just look between neighbors
+	p := d.HeapStart
+	for i := range d.objects {
+		x := &d.objects[i]
+		add(p, x.Addr)
+		if end := x.Addr + x.Ft.Size; end > p {
+			p = end
+		}
+	}
+	add(p, d.HeapEnd)
+	return total, gaps
+}
+
+// InternalFragmentation returns the number of bytes inside objects
+// which the objects' types don't use: the space lost to rounding
+// allocations up to a size class (or to whole pages).  For arrays it
+// is the space after the last whole element.  Objects of unknown type
+// are not counted.  Together with the gaps found by Fragmentation,
+// this is overhead the program can't see directly.
+func (d *Dump) InternalFragmentation() uint64 {
+	var total uint64
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		if ft.Typ == nil || ft.Typ.Size == 0 || ft.Size < ft.Typ.Size {
+			continue
+		}
+		switch ft.Kind {
+		case TypeKindObject:
+			total += ft.Size - ft.Typ.Size
+		case TypeKindArray:
+			total += ft.Size % ft.Typ.Size
+		}
+	}
+	return total
+}
+
+// TotalShallowSize returns the total size of all objects.  It should
+// be close to Memstats.HeapAlloc; a big difference means object
+// records are missing from the dump, or the runtime counts something
+// the dump doesn't show.
+func (d *Dump) TotalShallowSize() uint64 {
+	var total uint64
+	for i := range d.objects {
+		total += d.objects[i].Ft.Size
+	}
+	return total
+}
+
+// A TypeStat summarizes the objects of one full type.
+type TypeStat struct {
+	Ft    *FullType
+	Count int    // number of objects
+	Bytes uint64 // total size of those objects
+}
+
+// TypeHistogram returns the number of objects and bytes used by each
+// full type, sorted by decreasing total bytes.
+func (d *Dump) TypeHistogram() []TypeStat {
+	h := make([]TypeStat, len(d.FTList))
+	for i := range d.objects {
+		x := &d.objects[i]
+		s := &h[x.Ft.Id]
+		s.Count++
+		s.Bytes += x.Ft.Size
+	}
+	return sortedTypeStats(d, h)
+}
+
+// DeadTypeHistogram is like TypeHistogram, but only counts objects
+// which are not reachable from any root.  Such objects are garbage
+// which the collector has not freed yet.
+func (d *Dump) DeadTypeHistogram() []TypeStat {
+	reachable := d.Reachable()
+	h := make([]TypeStat, len(d.FTList))
+	for i := range d.objects {
+		if reachable.Has(ObjId(i)) {
+			continue
+		}
+		x := &d.objects[i]
+		s := &h[x.Ft.Id]
+		s.Count++
+		s.Bytes += x.Ft.Size
+	}
+	return sortedTypeStats(d, h)
+}
+
+// TypeHistogramSampled is like TypeHistogram, but only looks at every
+// rate'th object and scales the results up by rate.  The results are
+// estimates: types with fewer than about rate objects may be missing
+// entirely or have their counts greatly overstated, and only the
+// large entries near the top of the histogram are reliable.  Use
+// TypeHistogram for exact results.
+func (d *Dump) TypeHistogramSampled(rate int) []TypeStat {
+	if rate <= 1 {
+		return d.TypeHistogram()
+	}
+	h := make([]TypeStat, len(d.FTList))
+	// Start at a random object so repeated samples see different objects.
+	for i := rand.Intn(rate); i < len(d.objects); i += rate {
+		x := &d.objects[i]
+		s := &h[x.Ft.Id]
+		s.Count += rate
+		s.Bytes += uint64(rate) * x.Ft.Size
+	}
+	return sortedTypeStats(d, h)
+}
+
+// ExceededExpectations returns the entries of TypeHistogram for the
+// full types named in baselines which have more objects than the
+// baseline count, the worst (by ratio of count to baseline) first.
+// Baselines encode what is known about the program, e.g. that there
+// are never more than 1000 connections, so the baseline should be the
+// most objects that are ever expected, not the usual number.
+func (d *Dump) ExceededExpectations(baselines map[string]int) []TypeStat {
+	var r []TypeStat
+	for _, s := range d.TypeHistogram() {
+		if b, ok := baselines[s.Ft.Name]; ok && s.Count > b {
+			r = append(r, s)
+		}
+	}
+	sort.Stable(byExcess{r, baselines})
+	return r
+}
+
+type byExcess struct {
+	s         []TypeStat
+	baselines map[string]int
+}
+
+func (a byExcess) Len() int      { return len(a.s) }
+func (a byExcess) Swap(i, j int) { a.s[i], a.s[j] = a.s[j], a.s[i] }
+func (a byExcess) Less(i, j int) bool {
+	// compare count/baseline without dividing by a zero baseline
+	bi, bj := a.baselines[a.s[i].Ft.Name], a.baselines[a.s[j].Ft.Name]
+	return int64(a.s[i].Count)*int64(bj) > int64(a.s[j].Count)*int64(bi)
+}
+
+// sortedTypeStats fills in the type of each entry in h, which is
+// indexed by full type id, and returns the non-empty entries sorted
+// by decreasing bytes.
+func sortedTypeStats(d *Dump, h []TypeStat) []TypeStat {
+	var r []TypeStat
+	for id, s := range h {
+		if s.Count == 0 {
+			continue
+		}
+		s.Ft = d.FTList[id]
+		r = append(r, s)
+	}
+	sort.Sort(byTypeStatBytes(r))
+	return r
+}
+
+type byTypeStatBytes []TypeStat
+
+func (a byTypeStatBytes) Len() int           { return len(a) }
+func (a byTypeStatBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTypeStatBytes) Less(i, j int) bool { return a[i].Bytes > a[j].Bytes }
+
+// A SizeBucket counts the objects with sizes in [Min,Max).
+type SizeBucket struct {
+	Min, Max uint64
+	Count    int
+	Bytes    uint64 // total size of those objects
+}
+
+// TypeSizeDistribution returns a histogram of the sizes of the
+// objects of type t, including arrays of t, in power of two buckets.
+// Only non-empty buckets are returned, smallest sizes first.  An
+// average size hides mixes like many tiny buffers plus a few giant
+// ones; this doesn't.
+func (d *Dump) TypeSizeDistribution(t *Type) []SizeBucket {
+	var h [65]SizeBucket
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		if ft.Typ != t {
+			continue
+		}
+		b := &h[bits.Len64(ft.Size)]
+		b.Count++
+		b.Bytes += ft.Size
+	}
+	var r []SizeBucket
+	for k, b := range h {
+		if b.Count == 0 {
+			continue
+		}
+		b.Max = 1 << uint(k)
+		if k > 0 {
+			b.Min = b.Max / 2
+		}
+		r = append(r, b)
+	}
+	return r
+}
+
+// PointerOffsets counts, for each pointer-aligned offset in type t,
+// how many instances of t have a word pointing into the heap there.
+// Elements of arrays of t count as instances.  Every word is looked
+// at, not just t's fields, so comparing the result with t.Fields shows
+// pointers the field list is missing (and fields which are always
+// nil).  It returns nil if the contents have been dropped.
+func (d *Dump) PointerOffsets(t *Type) map[uint64]int {
+	if !d.HasContents() || t.Size == 0 {
+		return nil
+	}
+	m := map[uint64]int{}
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		if ft.Typ != t || ft.Kind != TypeKindObject && ft.Kind != TypeKindArray {
+			continue
+		}
+		b := d.Contents(ObjId(i))
+		for base := uint64(0); base+t.Size <= ft.Size; base += t.Size {
+			for off := uint64(0); off+d.PtrSize <= t.Size; off += d.PtrSize {
+				if d.FindObj(readPtr(d, b[base+off:])) != ObjNil {
+					m[off]++
+				}
+			}
+			if ft.Kind == TypeKindObject {
+				break
+			}
+		}
+	}
+	return m
+}
+
+// A PackageStat summarizes the objects whose types are declared in
+// one package.
+type PackageStat struct {
+	Package string // "" for builtin, unnamed, and unknown types
+	Count   int
+	Bytes   uint64
+}
+
+// PackageHistogram returns the number of objects and bytes used by
+// the types of each package, sorted by decreasing total bytes.
+// Composite types are attributed to the package of their element
+// type, so []*http.Request is counted in http.
+func (d *Dump) PackageHistogram() []PackageStat {
+	m := map[string]*PackageStat{}
+	for _, s := range d.TypeHistogram() {
+		var pkg string
+		if s.Ft.Typ != nil {
+			pkg = typePackage(s.Ft.Typ.Name)
+		}
+		p := m[pkg]
+		if p == nil {
+			p = &PackageStat{Package: pkg}
+			m[pkg] = p
+		}
+		p.Count += s.Count
+		p.Bytes += s.Bytes
+	}
+	var r []PackageStat
+	for _, p := range m {
+		r = append(r, *p)
+	}
+	sort.Sort(byPackageStatBytes(r))
+	return r
+}
+
+// typePackage returns the package part of the type name t.
+func typePackage(t string) string {
+	// strip pointer, slice, and array prefixes
+	t = strings.TrimLeft(t, "*[]0123456789")
+	if strings.HasPrefix(t, "map.") || strings.ContainsAny(t, "( {") {
+		// runtime map internals like map.hdr[K]V, or a type literal
+		// like func(*net/http.Request), chan int, or struct { ... }
+		return ""
+	}
+	// drop type arguments
+	if i := strings.Index(t, "["); i >= 0 {
+		t = t[:i]
+	}
+	// package paths may contain dots, but only before the last slash
+	i := strings.LastIndex(t, "/") + 1
+	j := strings.Index(t[i:], ".")
+	if j < 0 {
+		// builtin type
+		return ""
+	}
+	return t[:i+j]
+}
+
+// CollapseGenerics replaces the type arguments of every instantiated
+// generic type in the type name t with "...", so that
+// main.Cache[string,int] and main.Cache[int,string] both become
+// main.Cache[...].  Array, slice, and map types are left alone.  Use it
+// as Options.TypeNameFilter to see the total footprint of each generic
+// type in histograms.
+func CollapseGenerics(t string) string {
+	var b strings.Builder
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		b.WriteByte(c)
+		if c != '[' || !isGenericName(t[:i]) {
+			continue
+		}
+		// skip to the matching ]
+		depth := 1
+		j := i + 1
+		for ; j < len(t) && depth > 0; j++ {
+			switch t[j] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+		}
+		if depth != 0 {
+			// unbalanced, leave the rest alone
+			b.WriteString(t[i+1:])
+			break
+		}
+		b.WriteString("...]")
+		i = j - 1
+	}
+	return b.String()
+}
+
+// isGenericName reports whether the type name s, which is followed by
+// a [, is a generic type being instantiated (not an array or map).
+func isGenericName(s string) bool {
+	i := len(s)
+	for i > 0 && (isIdentByte(s[i-1]) || s[i-1] == '.' || s[i-1] == '/') {
+		i--
+	}
+	switch name := s[i:]; name {
+	case "", "map", "map.hdr", "map.bucket":
+		return false
+	default:
+		return isIdentByte(name[len(name)-1])
+	}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+type byPackageStatBytes []PackageStat
+
+func (a byPackageStatBytes) Len() int           { return len(a) }
+func (a byPackageStatBytes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPackageStatBytes) Less(i, j int) bool { return a[i].Bytes > a[j].Bytes }
+
+// A TypePadding reports the bytes lost to alignment padding in the
+// instances of one type.
+type TypePadding struct {
+	Type    *Type
+	Padding uint64 // padding bytes in one value of the type
+	Count   int    // number of values in the heap, including array elements
+	Bytes   uint64 // Padding * Count
+}
+
+// PaddingWaste uses the struct layouts in the Dwarf info w to compute
+// the padding between (and after) the fields of each type, and returns
+// the types with padding, most heap-wide waste first.  Padding in
+// nested structs and arrays counts toward the outer type.  Reordering
+// the fields of a type near the top of the list can shrink the heap.
+func (d *Dump) PaddingWaste(w *dwarf.Data) []TypePadding {
+	m := make(map[string]dwarfType)
+	for _, x := range typeMap(d, w) {
+		m[x.Name()] = x
+	}
+	pad := map[dwarfType]uint64{}
+	stats := map[*Type]*TypePadding{}
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		if ft.Typ == nil || ft.Typ.Size == 0 || ft.Kind == TypeKindChan {
+			continue
+		}
+		s := stats[ft.Typ]
+		if s == nil {
+			dt := m[ft.Typ.Name]
+			if dt == nil {
+				continue
+			}
+			s = &TypePadding{Type: ft.Typ, Padding: padding(dt, pad)}
+			stats[ft.Typ] = s
+		}
+		if ft.Kind == TypeKindArray {
+			s.Count += int(ft.Size / ft.Typ.Size)
+		} else {
+			s.Count++
+		}
+	}
+	var r []TypePadding
+	for _, s := range stats {
+		if s.Padding == 0 {
+			continue
+		}
+		s.Bytes = s.Padding * uint64(s.Count)
+		r = append(r, *s)
+	}
+	sort.Sort(byPaddingBytes(r))
+	return r
+}
+
+// padding returns the number of padding bytes in a value of type t.
+// Results are memoized in cache.
+func padding(t dwarfType, cache map[dwarfType]uint64) uint64 {
+	if p, ok := cache[t]; ok {
+		return p
+	}
+	var p uint64
+	switch t := t.(type) {
+	case *dwarfTypedef:
+		p = padding(t.type_, cache)
+	case *dwarfArrayType:
+		if t.elem != nil && t.elem.Size() != 0 {
+			p = t.Size() / t.elem.Size() * padding(t.elem, cache)
+		}
+	case *dwarfStructType:
+		used := uint64(0)
+		for _, m := range t.members {
+			used += m.type_.Size()
+			p += padding(m.type_, cache)
+		}
+		if used < t.Size() {
+			p += t.Size() - used
+		}
+	}
+	cache[t] = p
+	return p
+}
+
+type byPaddingBytes []TypePadding
+
+func (a byPaddingBytes) Len() int      { return len(a) }
+func (a byPaddingBytes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPaddingBytes) Less(i, j int) bool {
+	if a[i].Bytes != a[j].Bytes {
+		return a[i].Bytes > a[j].Bytes
+	}
+	return a[i].Type.Name < a[j].Type.Name
+}
+
+// A DupStat describes a group of strings or byte slices in the heap
+// with identical contents.
+type DupStat struct {
+	Sample string // the contents, cut to maxStringShown bytes
+	Len    uint64 // length of the contents
+	Count  int    // number of distinct copies
+	Wasted uint64 // bytes which interning would save: Len*(Count-1)
+}
+
+// DuplicateBytes finds the strings and byte slices held in heap
+// objects whose contents are identical but which don't share backing
+// store, and returns the groups of duplicates, most wasted bytes first.
+// Byte slices are only recognized if the field types are known from
+// Dwarf info.  Contents are compared by hash, so there is a small
+// chance of unequal contents being grouped together.
+func (d *Dump) DuplicateBytes() []DupStat {
+	type loc struct {
+		x   ObjId
+		off uint64
+		n   uint64
+	}
+	type key struct {
+		h uint64
+		n uint64
+	}
+	seen := map[loc]bool{}
+	dups := map[key]*DupStat{}
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		var b []byte
+		for _, f := range ft.Fields {
+			if f.Kind != FieldKindString && !(f.Kind == FieldKindSlice && (f.BaseType == "uint8" || f.BaseType == "byte")) {
+				continue
+			}
+			if b == nil {
+				b = d.Contents(ObjId(i))
+			}
+			if f.Offset+2*d.PtrSize > uint64(len(b)) {
+				continue
+			}
+			p := readPtr(d, b[f.Offset:])
+			n := readPtr(d, b[f.Offset+d.PtrSize:])
+			y := d.FindObj(p)
+			if y == ObjNil || n == 0 {
+				continue
+			}
+			l := loc{y, p - d.Addr(y), n}
+			if seen[l] {
+				continue // shares backing store with one we've seen
+			}
+			seen[l] = true
+			c := d.Contents(y)
+			if l.off+n > uint64(len(c)) {
+				continue
+			}
+			c = c[l.off : l.off+n]
+			h := fnv.New64a()
+			h.Write(c)
+			k := key{h.Sum64(), n}
+			s := dups[k]
+			if s == nil {
+				if len(c) > maxStringShown {
+					c = c[:maxStringShown]
+				}
+				s = &DupStat{Sample: string(c), Len: n}
+				dups[k] = s
+			}
+			s.Count++
+		}
+	}
+	var r []DupStat
+	for _, s := range dups {
+		if s.Count > 1 {
+			s.Wasted = s.Len * uint64(s.Count-1)
+			r = append(r, *s)
+		}
+	}
+	sort.Sort(byDupWasted(r))
+	return r
+}
+
+type byDupWasted []DupStat
+
+func (a byDupWasted) Len() int      { return len(a) }
+func (a byDupWasted) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDupWasted) Less(i, j int) bool {
+	if a[i].Wasted != a[j].Wasted {
+		return a[i].Wasted > a[j].Wasted
+	}
+	return a[i].Sample < a[j].Sample
+}
+
+// LargestObjects returns the n largest objects in the heap, largest
+// first, or all of them if n < 0.  Objects of the same size are in
+// address order.  Use ObjSummary to describe them.
+func (d *Dump) LargestObjects(n int) []ObjId {
+	if n < 0 || n > d.NumObjects() {
+		n = d.NumObjects()
+	}
+	// h is a min-heap of the n largest objects seen so far, with the
+	// smallest of them at the top.
+	h := bySize{d, make([]ObjId, 0, n)}
+	for i := 0; i < d.NumObjects(); i++ {
+		x := ObjId(i)
+		if len(h.objs) < n {
+			h.objs = append(h.objs, x)
+			h.up(len(h.objs) - 1)
+		} else if n > 0 && h.before(x, h.objs[0]) {
+			h.objs[0] = x
+			h.down(0)
+		}
+	}
+	sort.Sort(h)
+	return h.objs
+}
+
+// bySize sorts objects largest first, and objects of the same size in
+// address order.
+type bySize struct {
+	d    *Dump
+	objs []ObjId
+}
+
+func (a bySize) Len() int      { return len(a.objs) }
+func (a bySize) Swap(i, j int) { a.objs[i], a.objs[j] = a.objs[j], a.objs[i] }
+func (a bySize) Less(i, j int) bool {
+	return a.before(a.objs[i], a.objs[j])
+}
+
+// before reports whether x sorts before y.
+func (a bySize) before(x, y ObjId) bool {
+	if sx, sy := a.d.Size(x), a.d.Size(y); sx != sy {
+		return sx > sy
+	}
+	return x < y
+}
+
+// up and down maintain a.objs as a heap whose top is the object which
+// sorts last.
+func (a bySize) up(i int) {
+	for i > 0 {
+		p := (i - 1) / 2
+		if !a.before(a.objs[p], a.objs[i]) {
+			break
+		}
+		a.Swap(i, p)
+		i = p
+	}
+}
+
+func (a bySize) down(i int) {
+	for {
+		c := 2*i + 1
+		if c >= len(a.objs) {
+			break
+		}
+		if c+1 < len(a.objs) && a.before(a.objs[c], a.objs[c+1]) {
+			c++
+		}
+		if !a.before(a.objs[i], a.objs[c]) {
+			break
+		}
+		a.Swap(i, c)
+		i = c
+	}
+}
+
+// A Tally is a count of things with the same name.
+type Tally struct {
+	Name  string
+	Count int
+}
+
+// GoroutineStates counts the goroutines in each state (see
+// StatusString), most common first.  Waiting goroutines are counted
+// under "waiting"; use WaitReasons to break them down.
+func (d *Dump) GoroutineStates() []Tally {
+	m := map[string]int{}
+	for _, g := range d.Goroutines {
+		if g.Status == 4 {
+			m["waiting"]++
+		} else {
+			m[g.StatusString()]++
+		}
+	}
+	return sortedTallies(m)
+}
+
+// WaitReasons counts the waiting goroutines by the reason they are
+// waiting, most common first.
+func (d *Dump) WaitReasons() []Tally {
+	m := map[string]int{}
+	for _, g := range d.Goroutines {
+		if g.Status == 4 {
+			m[g.WaitReason]++
+		}
+	}
+	return sortedTallies(m)
+}
+
+func sortedTallies(m map[string]int) []Tally {
+	var r []Tally
+	for name, n := range m {
+		r = append(r, Tally{name, n})
+	}
+	sort.Sort(byTallyCount(r))
+	return r
+}
+
+type byTallyCount []Tally
+
+func (a byTallyCount) Len() int      { return len(a) }
+func (a byTallyCount) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byTallyCount) Less(i, j int) bool {
+	if a[i].Count != a[j].Count {
+		return a[i].Count > a[j].Count
+	}
+	return a[i].Name < a[j].Name
+}
+
+// A ContentionStat is a group of goroutines which appear to be
+// blocked on the same object.
+type ContentionStat struct {
+	Obj        ObjId // channel, or object containing the lock
+	WaitReason string
+	Goroutines []*GoRoutine
+}
+
+// ContendedObjects groups the waiting goroutines by the object they
+// appear to be blocked on, and returns the groups of at least two
+// goroutines, largest first.  The object a goroutine is blocked on is
+// taken to be any object pointed to by the runtime and sync frames at
+// the top of its stack (e.g. the channel argument of runtime.chanrecv),
+// or by its innermost frame if there are none.  Many goroutines stuck
+// on one object point to contention, or to a deadlock.
+func (d *Dump) ContendedObjects() []ContentionStat {
+	type key struct {
+		obj    ObjId
+		reason string
+	}
+	m := map[key][]*GoRoutine{}
+	for _, g := range d.Goroutines {
+		if g.Status != 4 || g.Bos == nil {
+			continue
+		}
+		seen := map[ObjId]bool{}
+		for f := g.Bos; f != nil; f = f.Parent {
+			blocking := strings.HasPrefix(f.Name, "runtime.") || strings.HasPrefix(f.Name, "sync.")
+			if !blocking && f != g.Bos {
+				break
+			}
+			for _, e := range f.Edges {
+				if !seen[e.To] {
+					seen[e.To] = true
+					k := key{e.To, g.WaitReason}
+					m[k] = append(m[k], g)
+				}
+			}
+			if !blocking {
+				break
+			}
+		}
+	}
+	var r []ContentionStat
+	for k, gs := range m {
+		if len(gs) >= 2 {
+			r = append(r, ContentionStat{k.obj, k.reason, gs})
+		}
+	}
+	sort.Sort(byContention(r))
+	return r
+}
+
+type byContention []ContentionStat
+
+func (a byContention) Len() int      { return len(a) }
+func (a byContention) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byContention) Less(i, j int) bool {
+	if len(a[i].Goroutines) != len(a[j].Goroutines) {
+		return len(a[i].Goroutines) > len(a[j].Goroutines)
+	}
+	if a[i].Obj != a[j].Obj {
+		return a[i].Obj < a[j].Obj
+	}
+	return a[i].WaitReason < a[j].WaitReason
+}
+
+// An InteriorStat counts the edges landing in objects of one full
+// type, and how many of them land in the middle of the object.
+type InteriorStat struct {
+	Ft       *FullType // nil for the totals over all types
+	Edges    int
+	Interior int // edges with a nonzero ToOffset
+}
+
+// Fraction returns the fraction of the edges which are interior.
+func (s InteriorStat) Fraction() float64 {
+	if s.Edges == 0 {
+		return 0
+	}
+	return float64(s.Interior) / float64(s.Edges)
+}
+
+// InteriorPointerStats counts the edges between objects which point
+// into the middle of their destination, in total and by destination
+// type (types with interior edges only, most interior edges first).
+// Some are normal, e.g. pointers to struct fields, but lots of them
+// can mean subslices keeping big arrays alive, or unsafe pointer
+// arithmetic.
+func (d *Dump) InteriorPointerStats() (total InteriorStat, byType []InteriorStat) {
+	h := make([]InteriorStat, len(d.FTList))
+	for i := 0; i < d.NumObjects(); i++ {
+		for _, e := range d.edges(ObjId(i)) {
+			s := &h[d.Ft(e.To).Id]
+			s.Edges++
+			total.Edges++
+			if e.ToOffset != 0 {
+				s.Interior++
+				total.Interior++
+			}
+		}
+	}
+	for id, s := range h {
+		if s.Interior == 0 {
+			continue
+		}
+		s.Ft = d.FTList[id]
+		byType = append(byType, s)
+	}
+	sort.Sort(byInterior(byType))
+	return total, byType
+}
+
+type byInterior []InteriorStat
+
+func (a byInterior) Len() int           { return len(a) }
+func (a byInterior) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byInterior) Less(i, j int) bool { return a[i].Interior > a[j].Interior }
+
+// A FinalizerInfo describes a finalizer set with runtime.SetFinalizer.
+type FinalizerInfo struct {
+	Obj     ObjId  // object to be finalized, ObjNil if not in the heap
+	ObjType *Type  // type of the object, nil if not in the dump
+	ArgType *Type  // type of the finalizer's argument, nil if not in the dump
+	Fn      uint64 // address of the finalizer function value
+	Code    uint64 // address of the finalizer's code
+	Queued  bool   // the object is dead and the finalizer is ready to run
+}
+
+// FinalizerInfos returns the finalizers of the dump, the pending ones
+// (whose objects are still live) first, then the queued ones.  Types
+// are only known if the dump has records for them, which it does for
+// the types of heap objects; ObjType is taken from the object itself
+// when it is in the heap, as the finalizer record only has the type of
+// the pointer to it.  Lots of finalizers on one type can explain why
+// memory is released slowly: an object with a finalizer, and
+// everything it points to, survives at least one more collection.
+func (d *Dump) FinalizerInfos() []FinalizerInfo {
+	var r []FinalizerInfo
+	for _, f := range d.Finalizers {
+		r = append(r, d.finalizerInfo(f.obj, f.fn, f.code, f.fint, f.ot, false))
+	}
+	for _, f := range d.QFinal {
+		r = append(r, d.finalizerInfo(f.obj, f.fn, f.code, f.fint, f.ot, true))
+	}
+	return r
+}
+
+func (d *Dump) finalizerInfo(obj, fn, code, fint, ot uint64, queued bool) FinalizerInfo {
+	i := FinalizerInfo{Obj: d.FindObj(obj), ObjType: d.TypeMap[ot], ArgType: d.TypeMap[fint], Fn: fn, Code: code, Queued: queued}
+	if i.Obj != ObjNil && d.Ft(i.Obj).Typ != nil {
+		i.ObjType = d.Ft(i.Obj).Typ
+	}
+	return i
+}
@@ -0,0 +1,152 @@
+package read
+
+// Subgraph returns a new Dump containing only the objects reachable
+// from the given objects using paths of at most maxDepth edges (0
+// means no limit).  Objects keep their addresses and types, but get
+// new ObjIds.  The new Dump has no stack frames, goroutines, or
+// globals; instead each of the given objects is referenced by an
+// OtherRoot with the description "subgraph root".
+func (d *Dump) Subgraph(roots []ObjId, maxDepth int) *Dump {
+	keep := NewBitset(d.NumObjects())
+	var q []ObjId
+	for _, x := range roots {
+		if !keep.Has(x) {
+			keep.Add(x)
+			q = append(q, x)
+		}
+	}
+	d.mark(q, keep, 0, maxDepth)
+	return d.extract(keep, roots, "subgraph root")
+}
+
+// GoroutineSubgraph is like Subgraph, but starts from the objects g
+// holds: the objects pointed to by its stack frames, its context, and
+// its defers and panics.  It shows what a single goroutine, e.g. a
+// leaked one, is keeping alive.
+func (d *Dump) GoroutineSubgraph(g *GoRoutine, maxDepth int) *Dump {
+	var roots []ObjId
+	seen := map[ObjId]bool{}
+	add := func(edges []Edge) {
+		for _, e := range edges {
+			if !seen[e.To] {
+				seen[e.To] = true
+				roots = append(roots, e.To)
+			}
+		}
+	}
+	add(g.Edges)
+	for f := g.Bos; f != nil; f = f.Parent {
+		add(f.Edges)
+	}
+	return d.Subgraph(roots, maxDepth)
+}
+
+// RetentionGraph returns a new Dump containing all the instances of
+// the named type which are reachable, and all the objects which
+// retain them: every object on some path from a root to an instance.
+// typeName matches either the full type name or the type's name, so
+// arrays and channels of the type are included.  The roots of the new
+// Dump are the objects pointed to directly by roots in d, each
+// referenced by an OtherRoot with the description "retention root".
+func (d *Dump) RetentionGraph(typeName string) *Dump {
+	reachable := d.Reachable()
+	idx, ref := d.liveReverseEdges()
+
+	// walk backwards from the instances to the roots
+	keep := NewBitset(d.NumObjects())
+	var q []ObjId
+	for i := range d.objects {
+		ft := d.objects[i].Ft
+		if reachable.Has(ObjId(i)) && (ft.Name == typeName || ft.Typ != nil && ft.Typ.Name == typeName) {
+			keep.Add(ObjId(i))
+			q = append(q, ObjId(i))
+		}
+	}
+	for len(q) > 0 {
+		x := q[len(q)-1]
+		q = q[:len(q)-1]
+		for _, y := range ref[idx[x]:idx[x+1]] {
+			// dead referrers don't retain anything
+			if !keep.Has(y) && reachable.Has(y) {
+				keep.Add(y)
+				q = append(q, y)
+			}
+		}
+	}
+
+	var roots []ObjId
+	seen := make(map[ObjId]bool)
+	d.forEachRootEdge(func(e Edge) {
+		if keep.Has(e.To) && !seen[e.To] {
+			seen[e.To] = true
+			roots = append(roots, e.To)
+		}
+	})
+	return d.extract(keep, roots, "retention root")
+}
+
+// extract returns a new Dump containing the objects in keep.  Each of
+// roots gets a synthetic OtherRoot with the given description.
+func (d *Dump) extract(keep Bitset, roots []ObjId, desc string) *Dump {
+	s := &Dump{
+		Order:      d.Order,
+		PtrSize:    d.PtrSize,
+		HChanSize:  d.HChanSize,
+		HeapStart:  d.HeapStart,
+		HeapEnd:    d.HeapEnd,
+		TheChar:    d.TheChar,
+		Experiment: d.Experiment,
+		Ncpu:       d.Ncpu,
+		Types:      d.Types,
+		Memstats:   d.Memstats,
+		Data:       &Data{Addr: d.Data.Addr},
+		Bss:        &Data{Addr: d.Bss.Addr},
+		r:          d.r,
+		FTList:     d.FTList,
+		TypeMap:    d.TypeMap,
+		ItabMap:    d.ItabMap,
+	}
+	s.conservative = d.conservative
+	s.nonRetaining = d.nonRetaining
+	s.normalized = d.normalized
+	s.version = d.version
+	s.extracted = true
+	// Objects are copied in order, so they stay sorted by address.
+	kept := keep.Objects()
+	for i, x := range kept {
+		s.objects = append(s.objects, d.objects[x])
+		if p, ok := d.patched[x]; ok {
+			if s.patched == nil {
+				s.patched = map[ObjId][]byte{}
+			}
+			s.patched[ObjId(i)] = p
+		}
+	}
+	s.buildIndex()
+
+	if d.edgeidx != nil {
+		// The contents are gone, so the cached edges must be
+		// translated instead.  Edges to objects not kept are dropped.
+		s.edgeidx = make([]int, len(kept)+1)
+		for i, x := range kept {
+			for _, e := range d.edges(x) {
+				if keep.Has(e.To) {
+					e.To = s.FindObj(d.Addr(e.To))
+					s.edgeref = append(s.edgeref, e)
+				}
+			}
+			s.edgeidx[i+1] = len(s.edgeref)
+		}
+	}
+
+	for _, x := range roots {
+		y := s.FindObj(d.Addr(x))
+		s.Otherroots = append(s.Otherroots, &OtherRoot{
+			Description: desc,
+			Edges:       []Edge{{y, 0, 0, ""}},
+			toaddr:      d.Addr(x),
+			synthetic:   true,
+		})
+	}
+	return s
+}
@@ -0,0 +1,281 @@
+package read
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// WriteRedactedDump writes d to w as a heap dump which Read can read,
+// with everything but pointers zeroed: objects, stack frames, and
+// globals keep only their pointers into the heap (and the lengths of
+// strings and slices), and objects without pointers, like the bytes of
+// strings, are all zeros.  Addresses, types, sizes, and edges are unchanged, so
+// the result can be shared to reproduce a problem with the structure
+// of a heap without giving away the data in it.
+func (d *Dump) WriteRedactedDump(w io.Writer) error {
+	if !d.HasContents() {
+		return errors.New("object contents have been dropped")
+	}
+	b := &dumpWriter{w: bufio.NewWriter(w)}
+	b.w.WriteString(dumpVersion + " heap dump\n")
+
+	b.uint64(tagParams)
+	if d.Order == binary.LittleEndian {
+		b.uint64(0)
+	} else {
+		b.uint64(1)
+	}
+	b.uint64(d.PtrSize)
+	b.uint64(d.HChanSize)
+	b.uint64(d.HeapStart)
+	b.uint64(d.HeapEnd)
+	b.uint64(uint64(d.TheChar))
+	b.string(d.Experiment)
+	b.uint64(d.Ncpu)
+
+	// types must come before the objects which use them
+	for _, t := range d.Types {
+		b.uint64(tagType)
+		b.uint64(t.Addr)
+		b.uint64(t.Size)
+		b.string(t.Name)
+		b.bool(t.efaceptr)
+		b.fields(t.dumpFields)
+	}
+	for addr, ptr := range d.ItabMap {
+		b.uint64(tagItab)
+		b.uint64(addr)
+		b.bool(ptr)
+	}
+	for i := range d.objects {
+		x := &d.objects[i]
+		b.uint64(tagObject)
+		b.uint64(x.Addr)
+		if x.Ft.Typ != nil {
+			b.uint64(x.Ft.Typ.Addr)
+		} else {
+			b.uint64(0)
+		}
+		b.uint64(uint64(x.Ft.Kind))
+		b.uint64(x.Ft.Size)
+		b.w.Write(d.redact(d.Contents(ObjId(i)), x.Ft.Fields))
+	}
+
+	for _, r := range d.Otherroots {
+		b.uint64(tagOtherRoot)
+		b.string(r.Description)
+		b.uint64(r.toaddr)
+	}
+	for _, g := range d.Goroutines {
+		b.uint64(tagGoRoutine)
+		b.uint64(g.Addr)
+		b.uint64(g.bosaddr)
+		b.uint64(g.Goid)
+		b.uint64(g.Gopc)
+		b.uint64(g.Status)
+		b.bool(g.IsSystem)
+		b.bool(g.IsBackground)
+		b.uint64(g.WaitSince)
+		b.string(g.WaitReason)
+		b.uint64(g.ctxtaddr)
+		b.uint64(g.maddr)
+		b.uint64(g.deferaddr)
+		b.uint64(g.panicaddr)
+	}
+	for _, f := range d.Frames {
+		b.uint64(tagStackFrame)
+		b.uint64(f.Addr)
+		b.uint64(f.Depth)
+		b.uint64(f.childaddr)
+		b.bytes(d.redact(append([]byte(nil), f.Data...), f.Fields))
+		b.uint64(f.entry)
+		b.uint64(f.pc)
+		b.uint64(f.pc) // continpc isn't kept
+		b.string(f.Name)
+		b.fields(f.Fields)
+	}
+	for _, f := range d.Finalizers {
+		b.uint64(tagFinalizer)
+		b.uint64(f.obj)
+		b.uint64(f.fn)
+		b.uint64(f.code)
+		b.uint64(f.fint)
+		b.uint64(f.ot)
+	}
+	for _, f := range d.QFinal {
+		b.uint64(tagQFinal)
+		b.uint64(f.obj)
+		b.uint64(f.fn)
+		b.uint64(f.code)
+		b.uint64(f.fint)
+		b.uint64(f.ot)
+	}
+	for _, x := range []struct {
+		tag  uint64
+		data *Data
+	}{{tagData, d.Data}, {tagBss, d.Bss}} {
+		b.uint64(x.tag)
+		b.uint64(x.data.Addr)
+		b.bytes(d.redact(append([]byte(nil), x.data.Data...), x.data.dumpFields))
+		b.fields(x.data.dumpFields)
+	}
+	for _, t := range d.Osthreads {
+		b.uint64(tagOSThread)
+		b.uint64(t.addr)
+		b.uint64(t.id)
+		b.uint64(t.procid)
+	}
+	if t := d.Memstats; t != nil {
+		b.uint64(tagMemStats)
+		for _, x := range []uint64{t.Alloc, t.TotalAlloc, t.Sys, t.Lookups, t.Mallocs, t.Frees,
+			t.HeapAlloc, t.HeapSys, t.HeapIdle, t.HeapInuse, t.HeapReleased, t.HeapObjects,
+			t.StackInuse, t.StackSys, t.MSpanInuse, t.MSpanSys, t.MCacheInuse, t.MCacheSys,
+			t.BuckHashSys, t.GCSys, t.OtherSys, t.NextGC, t.LastGC, t.PauseTotalNs} {
+			b.uint64(x)
+		}
+		for _, x := range t.PauseNs {
+			b.uint64(x)
+		}
+		b.uint64(uint64(t.NumGC))
+	}
+	for _, t := range d.Defers {
+		b.uint64(tagDefer)
+		b.uint64(t.addr)
+		b.uint64(t.gp)
+		b.uint64(t.argp)
+		b.uint64(t.pc)
+		b.uint64(t.fn)
+		b.uint64(t.code)
+		b.uint64(t.link)
+	}
+	for _, t := range d.Panics {
+		b.uint64(tagPanic)
+		b.uint64(t.addr)
+		b.uint64(t.gp)
+		b.uint64(t.typ)
+		b.uint64(t.data)
+		b.uint64(t.defr)
+		b.uint64(t.link)
+	}
+	// The memprof records are keyed by their index here; the
+	// original keys (bucket addresses) aren't kept.
+	keys := map[*MemProfEntry]uint64{}
+	for i, t := range d.MemProf {
+		keys[t] = uint64(i + 1)
+		b.uint64(tagMemProf)
+		b.uint64(uint64(i + 1))
+		b.uint64(t.size)
+		b.uint64(uint64(len(t.stack)))
+		for _, f := range t.stack {
+			b.string(f.Func)
+			b.string(f.File)
+			b.uint64(f.Line)
+		}
+		b.uint64(t.allocs)
+		b.uint64(t.frees)
+	}
+	for _, t := range d.AllocSamples {
+		b.uint64(tagAllocSample)
+		b.uint64(t.Addr)
+		b.uint64(keys[t.Prof])
+	}
+	b.uint64(tagEOF)
+	return b.w.Flush()
+}
+
+// redact zeroes everything in data except the pointers described by
+// fields which point into the heap, and the lengths and capacities of
+// strings and slices, and returns data.  The type word of an interface
+// is kept only if its data word is a kept pointer, as the reader needs
+// it to find that edge; other data words can hold non-pointer values.
+func (d *Dump) redact(data []byte, fields []Field) []byte {
+	keep := make([]bool, len(data))
+	word := func(off uint64) {
+		for i := off; i < off+d.PtrSize && i < uint64(len(data)); i++ {
+			keep[i] = true
+		}
+	}
+	ptr := func(off uint64) bool {
+		if off+d.PtrSize > uint64(len(data)) || d.FindObj(readPtr(d, data[off:])) == ObjNil {
+			return false
+		}
+		word(off)
+		return true
+	}
+	for _, f := range fields {
+		off := f.Offset
+		switch f.Kind {
+		case FieldKindPtr:
+			ptr(off)
+		case FieldKindString:
+			ptr(off)
+			word(off + d.PtrSize)
+		case FieldKindSlice:
+			ptr(off)
+			word(off + d.PtrSize)
+			word(off + 2*d.PtrSize)
+		case FieldKindEface, FieldKindIface:
+			ptr(off)
+			if off+d.PtrSize > uint64(len(data)) {
+				continue
+			}
+			tp := readPtr(d, data[off:])
+			var isPtr bool
+			if f.Kind == FieldKindEface {
+				t := d.TypeMap[tp]
+				isPtr = t != nil && t.EfaceDataIsPointer()
+			} else {
+				isPtr, _ = d.IfaceDataIsPointer(tp)
+			}
+			if isPtr && ptr(off+d.PtrSize) {
+				word(off)
+			}
+		}
+	}
+	for i := range data {
+		if !keep[i] {
+			data[i] = 0
+		}
+	}
+	return data
+}
+
+// dumpWriter writes the basic elements of the heap dump format.
+// Errors are sticky in the bufio.Writer and reported by Flush.
+type dumpWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (b *dumpWriter) uint64(x uint64) {
+	n := binary.PutUvarint(b.buf[:], x)
+	b.w.Write(b.buf[:n])
+}
+
+func (b *dumpWriter) bool(x bool) {
+	if x {
+		b.w.WriteByte(1)
+	} else {
+		b.w.WriteByte(0)
+	}
+}
+
+func (b *dumpWriter) bytes(x []byte) {
+	b.uint64(uint64(len(x)))
+	b.w.Write(x)
+}
+
+func (b *dumpWriter) string(x string) {
+	b.uint64(uint64(len(x)))
+	b.w.WriteString(x)
+}
+
+func (b *dumpWriter) fields(fs []Field) {
+	for _, f := range fs {
+		b.uint64(uint64(f.Kind))
+		b.uint64(f.Offset)
+	}
+	b.uint64(uint64(FieldKindEol))
+}